@@ -2,9 +2,12 @@ package clickhouse
 
 import (
 	"context"
+	"fmt"
+	"net"
 	"net/http"
 
 	ch "github.com/ClickHouse/clickhouse-go/v2"
+	errors "github.com/go-faster/errors"
 	testcontainers "github.com/testcontainers/testcontainers-go"
 	clickhouse "github.com/testcontainers/testcontainers-go/modules/clickhouse"
 	wait "github.com/testcontainers/testcontainers-go/wait"
@@ -20,6 +23,8 @@ const (
 	envDB   = "CLICKHOUSE_DB"
 	envUser = "CLICKHOUSE_USER"
 	envPass = "CLICKHOUSE_PASSWORD"
+
+	kind = "clickhouse"
 )
 
 type (
@@ -31,11 +36,20 @@ type (
 		DBPass string
 		DBPort string
 		DBHost string
+
+		// Internal* fields are set when the container has a network alias,
+		// see common.WithNetworkAlias.
+		InternalURI    string
+		InternalDBHost string
+		InternalDBPort string
+
+		name string
 	}
 )
 
 var (
-	defaultImage = common.DockerProxy("clickhouse/clickhouse-server:23")
+	_ common.Service  = (*Env)(nil)
+	_ common.Resetter = (*Env)(nil)
 )
 
 func (e *Env) Conn() (ch.Conn, error) { //nolint:ireturn
@@ -49,6 +63,124 @@ func (e *Env) Conn() (ch.Conn, error) { //nolint:ireturn
 	})
 }
 
+// ServiceName implements common.Service.
+func (e *Env) ServiceName() string {
+	return e.name
+}
+
+// Kind implements common.Service.
+func (e *Env) Kind() string {
+	return kind
+}
+
+// Endpoints implements common.Service.
+func (e *Env) Endpoints() map[string]string {
+	endpoints := map[string]string{
+		"native": net.JoinHostPort(e.DBHost, e.DBPort),
+	}
+	common.AddInternalEndpoint(endpoints, "native", common.InternalAddress(e.InternalDBHost, e.InternalDBPort))
+
+	return endpoints
+}
+
+// HealthCheck implements common.Service.
+func (e *Env) HealthCheck(ctx context.Context) error {
+	conn, err := e.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Ping(ctx)
+}
+
+// Reset implements common.Resetter. It drops the databases created after
+// start and all tables of the default and the configured database.
+func (e *Env) Reset(ctx context.Context) error {
+	conn, err := e.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	keep := []string{"system", "INFORMATION_SCHEMA", "information_schema", "default", e.DBName}
+
+	databases, err := queryNames(ctx, conn, "SELECT name FROM system.databases WHERE NOT has(?, name)", keep)
+	if err != nil {
+		return err
+	}
+
+	for _, database := range databases {
+		if err := conn.Exec(ctx, fmt.Sprintf("DROP DATABASE `%s` SYNC", database)); err != nil {
+			return errors.Wrapf(err, "drop database %s", database)
+		}
+	}
+
+	tables, err := queryNames(ctx, conn,
+		"SELECT concat('`', database, '`.`', name, '`') FROM system.tables WHERE has(?, database) AND NOT is_temporary",
+		[]string{"default", e.DBName})
+	if err != nil {
+		return err
+	}
+
+	for _, table := range tables {
+		if err := conn.Exec(ctx, "DROP TABLE "+table+" SYNC"); err != nil {
+			return errors.Wrapf(err, "drop table %s", table)
+		}
+	}
+
+	return nil
+}
+
+func queryNames(ctx context.Context, conn ch.Conn, query string, args ...any) ([]string, error) {
+	rows, err := conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+
+		names = append(names, name)
+	}
+
+	return names, rows.Err()
+}
+
+// ConnectionEnv implements common.Service.
+func (e *Env) ConnectionEnv() map[string]string {
+	return e.EnvVars("")
+}
+
+// EnvVars implements common.Service.
+func (e *Env) EnvVars(prefix string) map[string]string {
+	return common.PrefixEnv(prefix, map[string]string{
+		"CLICKHOUSE_DSN":      e.URI,
+		"CLICKHOUSE_HOST":     e.DBHost,
+		"CLICKHOUSE_PORT":     e.DBPort,
+		"CLICKHOUSE_USER":     e.DBUser,
+		"CLICKHOUSE_PASSWORD": e.DBPass,
+		"CLICKHOUSE_DB":       e.DBName,
+	})
+}
+
+// Load returns the Env of the named service from a document written by
+// common.WriteJSON. The Env has no container attached, so it must not be terminated.
+func Load(doc *common.Document, name string) (*Env, error) {
+	env := &Env{name: name}
+	if err := doc.Decode(name, env); err != nil {
+		return nil, err
+	}
+
+	return env, nil
+}
+
 func Run(ctx context.Context, opts ...testcontainers.ContainerCustomizer) (*Env, error) {
 	var (
 		req = testcontainers.GenericContainerRequest{
@@ -62,12 +194,15 @@ func Run(ctx context.Context, opts ...testcontainers.ContainerCustomizer) (*Env,
 		ok  bool
 	)
 
-	for _, e := range opts {
-		_ = e.Customize(&req) //nolint:errcheck
+	settings, err := common.Customize(kind, &req, opts...)
+	if err != nil {
+		return nil, err
 	}
 
 	if req.Image == "" {
-		req.Image = defaultImage
+		if req.Image, err = common.ResolveImage(kind); err != nil {
+			return nil, err
+		}
 	}
 
 	if env.DBUser, ok = req.Env[envUser]; !ok {
@@ -85,6 +220,9 @@ func Run(ctx context.Context, opts ...testcontainers.ContainerCustomizer) (*Env,
 		opts = append(opts, clickhouse.WithDatabase(env.DBName))
 	}
 
+	alias := common.NetworkAlias(&req)
+	env.name = common.ServiceName(&req, kind)
+
 	if req.WaitingFor == nil {
 		opts = append(opts, testcontainers.WithWaitStrategy(wait.ForAll(
 			wait.ForHTTP("/ping").WithPort("8123/tcp").WithStatusCodeMatcher(
@@ -95,30 +233,51 @@ func Run(ctx context.Context, opts ...testcontainers.ContainerCustomizer) (*Env,
 		)))
 	}
 
-	p, err := clickhouse.RunContainer(ctx, opts...)
-	if err != nil {
-		return nil, err
-	}
+	opts = append(common.ModuleOptions(&req), opts...)
 
-	env.URI, err = p.ConnectionString(ctx)
-	if err != nil {
-		return nil, err
-	}
+	p, err := common.Start(ctx, settings,
+		func(ctx context.Context) (*clickhouse.ClickHouseContainer, error) {
+			return clickhouse.Run(ctx, req.Image, opts...)
+		},
+		func(ctx context.Context, p *clickhouse.ClickHouseContainer) error {
+			uri, err := p.ConnectionString(ctx)
+			if err != nil {
+				return err
+			}
+			env.URI = uri
 
-	port, err := p.MappedPort(ctx, "9000/tcp")
-	if err != nil {
-		return nil, err
-	}
+			port, err := p.MappedPort(ctx, "9000/tcp")
+			if err != nil {
+				return err
+			}
+			env.DBPort = port.Port()
 
-	env.DBPort = port.Port()
+			host, err := p.Host(ctx)
+			if err != nil {
+				return err
+			}
+			env.DBHost = host
 
-	host, err := p.Host(ctx)
+			return nil
+		},
+	)
 	if err != nil {
 		return nil, err
 	}
 
-	env.DBHost = host
 	env.Container = p
 
+	if alias != "" {
+		env.InternalDBHost = alias
+		env.InternalDBPort = "9000"
+		env.InternalURI = fmt.Sprintf(
+			"clickhouse://%s:%s@%s/%s",
+			env.DBUser,
+			env.DBPass,
+			net.JoinHostPort(alias, env.InternalDBPort),
+			env.DBName,
+		)
+	}
+
 	return &env, nil
 }
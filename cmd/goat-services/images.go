@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	errors "github.com/go-faster/errors"
+	yaml "gopkg.in/yaml.v3"
+
+	common "github.com/Educentr/goat-services/common"
+)
+
+// imagesCommand manages the images of the catalog. Service arguments select
+// catalog entries, all services are used when none are given.
+func imagesCommand(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: goat-services images list|pull|save|load|lock [flags] [service...]")
+	}
+
+	fs := flag.NewFlagSet("images "+args[0], flag.ContinueOnError)
+
+	switch args[0] {
+	case "list":
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		refs, err := common.Images(fs.Args()...)
+		if err != nil {
+			return err
+		}
+
+		for _, ref := range refs {
+			fmt.Println(ref)
+		}
+
+		return nil
+
+	case "pull":
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		return common.EnsureImages(ctx, fs.Args()...)
+
+	case "save":
+		output := fs.String("o", "", "output tarball `file`")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		if *output == "" {
+			return errors.New("images save: -o is required")
+		}
+
+		if err := common.EnsureImages(ctx, fs.Args()...); err != nil {
+			return err
+		}
+
+		refs, err := common.Images(fs.Args()...)
+		if err != nil {
+			return err
+		}
+
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+
+		return errors.Join(common.SaveImages(ctx, f, refs...), f.Close())
+
+	case "load":
+		input := fs.String("i", "", "input tarball `file`")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		if *input == "" {
+			return errors.New("images load: -i is required")
+		}
+
+		f, err := os.Open(*input)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		return common.LoadImages(ctx, f)
+
+	case "lock":
+		output := fs.String("o", "", "write the overrides `file` for "+common.ImagesEnv+" instead of stdout")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		locked, err := common.LockImages(ctx, fs.Args()...)
+		if err != nil {
+			return err
+		}
+
+		refs := make(map[string]string, len(locked))
+		for service, img := range locked {
+			refs[service] = img.String()
+		}
+
+		data, err := yaml.Marshal(refs)
+		if err != nil {
+			return err
+		}
+
+		if *output == "" {
+			_, err = os.Stdout.Write(data)
+			return err
+		}
+
+		return os.WriteFile(*output, data, 0o644) //nolint:gosec // not a secret
+
+	default:
+		return errors.Errorf("unknown images command %q", args[0])
+	}
+}
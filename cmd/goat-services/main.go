@@ -0,0 +1,73 @@
+// Command goat-services prepares images for and runs the service containers
+// of the goat-services module outside of tests.
+//
+// Usage:
+//
+//	goat-services images list [service...]
+//	goat-services images pull [service...]
+//	goat-services images save -o bundle.tar [service...]
+//	goat-services images load -i bundle.tar
+//	goat-services images lock [-o images.yaml] [service...]
+//	goat-services up [-f goat-stack.yaml] [-env-file .goat.env] [-force] [-session id] [-detach]
+//	goat-services down [-session id | -env-file .goat.env]
+//	goat-services reap [-ttl 2h] [-dead=true] [-dry-run]
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+
+	errors "github.com/go-faster/errors"
+
+	common "github.com/Educentr/goat-services/common"
+)
+
+type command func(ctx context.Context, args []string) error
+
+var commands = map[string]command{
+	"images": imagesCommand,
+	"up":     upCommand,
+	"down":   downCommand,
+	"reap":   reapCommand,
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	common.SetLogger(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelInfo})))
+
+	if err := run(ctx, os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "goat-services:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return usage()
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		return errors.Join(errors.Errorf("unknown command %q", args[0]), usage())
+	}
+
+	return cmd(ctx, args[1:])
+}
+
+func usage() error {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return errors.Errorf("usage: goat-services <command> [arguments], commands: %v", names)
+}
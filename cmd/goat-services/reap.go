@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	common "github.com/Educentr/goat-services/common"
+)
+
+// reapCommand removes containers and networks left behind by killed test
+// processes, for runners without the Ryuk reaper.
+func reapCommand(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("reap", flag.ContinueOnError)
+	ttl := fs.Duration("ttl", 2*time.Hour, "remove resources older than `duration`, 0 to disable")
+	dead := fs.Bool("dead", true, "remove resources of exited processes of this host")
+	dryRun := fs.Bool("dry-run", false, "only list the resources that would be removed")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	result, err := common.Reap(ctx, common.ReapOptions{TTL: *ttl, DeadSessions: *dead, DryRun: *dryRun})
+	if result != nil {
+		for _, name := range result.Containers {
+			fmt.Println("container", name)
+		}
+
+		for _, name := range result.Networks {
+			fmt.Println("network", name)
+		}
+	}
+
+	return err
+}
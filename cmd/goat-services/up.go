@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	errors "github.com/go-faster/errors"
+	testcontainers "github.com/testcontainers/testcontainers-go"
+	yaml "gopkg.in/yaml.v3"
+
+	"github.com/Educentr/goat-services/clickhouse"
+	common "github.com/Educentr/goat-services/common"
+	"github.com/Educentr/goat-services/jaeger"
+	"github.com/Educentr/goat-services/kafka"
+	"github.com/Educentr/goat-services/minio"
+	"github.com/Educentr/goat-services/psql"
+	"github.com/Educentr/goat-services/redis"
+	"github.com/Educentr/goat-services/s3"
+	"github.com/Educentr/goat-services/singbox"
+	"github.com/Educentr/goat-services/stack"
+	"github.com/Educentr/goat-services/victoriametrics"
+	"github.com/Educentr/goat-services/xray"
+)
+
+const (
+	// ryukDisabledEnv keeps testcontainers from reaping detached stacks
+	// when the command exits.
+	ryukDisabledEnv = "TESTCONTAINERS_RYUK_DISABLED"
+
+	// defaultEnvFile is apart from the .env of the project.
+	defaultEnvFile = ".goat.env"
+)
+
+type (
+	// stackFile is the YAML description of a local stack.
+	//
+	//	services:
+	//	  postgres:
+	//	    image: postgres:16-alpine
+	//	    env:
+	//	      POSTGRES_DB: orders
+	//	  app-proxy:
+	//	    kind: xray
+	//	    config: ./xray.json
+	//	    depends_on: [postgres]
+	stackFile struct {
+		Services map[string]serviceSpec `yaml:"services"`
+	}
+
+	serviceSpec struct {
+		// Kind selects the service package, defaults to the service name.
+		Kind      string            `yaml:"kind"`
+		Image     string            `yaml:"image"`
+		Env       map[string]string `yaml:"env"`
+		Cmd       []string          `yaml:"cmd"`
+		Config    string            `yaml:"config"` // config file for xray and singbox
+		DependsOn []string          `yaml:"depends_on"`
+		EnvPrefix string            `yaml:"env_prefix"` // prefix of the written connection variables
+	}
+
+	// adder registers a service of some kind in the stack and returns an
+	// accessor for the started service.
+	adder func(s *stack.Stack, name string, deps []string, opts []testcontainers.ContainerCustomizer) func() common.Service
+
+	startedService interface {
+		stack.Terminator
+		common.Service
+	}
+)
+
+var kinds = map[string]adder{
+	"postgres":        add(psql.Run),
+	"redis":           add(redis.Run),
+	"clickhouse":      add(clickhouse.Run),
+	"kafka":           add(kafka.Run),
+	"s3":              add(s3.Run),
+	"minio":           add(minio.Run),
+	"jaeger":          add(jaeger.Run),
+	"victoriametrics": add(victoriametrics.Run),
+	"xray":            add(xray.Run),
+	"singbox":         add(singbox.Run),
+}
+
+var configOptions = map[string]func(path string) testcontainers.ContainerCustomizer{
+	"xray":    xray.WithConfigFile,
+	"singbox": singbox.WithConfigFile,
+}
+
+func add[T startedService](run stack.RunFunc[T]) adder {
+	return func(s *stack.Stack, name string, deps []string, opts []testcontainers.ContainerCustomizer) func() common.Service {
+		h := stack.Add(s, name, run, opts...).DependsOn(deps...)
+		return func() common.Service { return h.Env() }
+	}
+}
+
+// upCommand starts the services of a stack file, prints their connection
+// details and writes them to a dotenv file. Unless detached, it waits for an
+// interrupt and then removes the stack.
+func upCommand(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("up", flag.ContinueOnError)
+	file := fs.String("f", "goat-stack.yaml", "stack description `file`")
+	envFile := fs.String("env-file", defaultEnvFile, "dotenv `file` to write connection details to, empty to skip")
+	jsonFile := fs.String("json", "", "`file` to write a services document to, readable with common.ReadJSON")
+	session := fs.String("session", "", "session `id`, generated when empty")
+	detach := fs.Bool("detach", false, "exit after start and keep the stack running until `down`")
+	force := fs.Bool("force", false, "overwrite a dotenv file not written by up")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if !*force {
+		if err := checkEnvFile(*envFile); err != nil {
+			return err
+		}
+	}
+
+	spec, err := readStackFile(*file)
+	if err != nil {
+		return err
+	}
+
+	if *session == "" {
+		*session = common.NewSessionID()
+	}
+
+	if *detach {
+		if err := os.Setenv(ryukDisabledEnv, "true"); err != nil {
+			return err
+		}
+	}
+
+	labels := map[string]string{common.SessionLabel: *session}
+	if *detach {
+		labels[common.OwnerLabel] = common.DetachedOwner
+	}
+
+	s := stack.New(stack.WithLabels(labels))
+
+	names := slices.Sorted(maps.Keys(spec.Services))
+	services := make(map[string]func() common.Service, len(names))
+
+	for _, name := range names {
+		svc := spec.Services[name]
+
+		kind := svc.Kind
+		if kind == "" {
+			kind = name
+		}
+
+		addService, ok := kinds[kind]
+		if !ok {
+			return errors.Errorf("service %q: unknown kind %q", name, kind)
+		}
+
+		opts, err := svc.options(kind)
+		if err != nil {
+			return errors.Wrapf(err, "service %q", name)
+		}
+
+		services[name] = addService(s, name, svc.DependsOn, opts)
+	}
+
+	if err := s.Start(ctx); err != nil {
+		return err
+	}
+
+	started := make([]common.Service, 0, len(names))
+	for _, name := range names {
+		svc := services[name]()
+		if prefix := spec.Services[name].EnvPrefix; prefix != "" {
+			svc = common.WithEnvPrefix(prefix, svc)
+		}
+
+		printService(os.Stdout, svc)
+		started = append(started, svc)
+	}
+
+	if err := writeOutputs(*envFile, *jsonFile, *session, started); err != nil {
+		return errors.Join(err, s.Terminate(context.WithoutCancel(ctx)))
+	}
+
+	fmt.Printf("\nsession: %s\n", *session)
+
+	if *detach {
+		fmt.Printf("remove with: goat-services down -session %s\n", *session)
+		return nil
+	}
+
+	fmt.Println("press Ctrl+C to stop")
+	<-ctx.Done()
+
+	return s.Terminate(context.WithoutCancel(ctx))
+}
+
+// downCommand removes the containers and networks of a session.
+func downCommand(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("down", flag.ContinueOnError)
+	session := fs.String("session", "", "session `id` printed by up")
+	envFile := fs.String("env-file", defaultEnvFile, "dotenv `file` written by up, used when -session is empty")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *session == "" {
+		id, err := readSession(*envFile)
+		if err != nil {
+			return err
+		}
+
+		*session = id
+	}
+
+	return common.RemoveSession(ctx, *session)
+}
+
+func readStackFile(filename string) (*stackFile, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var spec stackFile
+	if err := yaml.Unmarshal(data, &spec); err != nil {
+		return nil, errors.Wrapf(err, "parse %s", filename)
+	}
+
+	if len(spec.Services) == 0 {
+		return nil, errors.Errorf("%s: no services", filename)
+	}
+
+	return &spec, nil
+}
+
+func (s serviceSpec) options(kind string) ([]testcontainers.ContainerCustomizer, error) {
+	var opts []testcontainers.ContainerCustomizer
+
+	if s.Image != "" {
+		opts = append(opts, testcontainers.WithImage(s.Image))
+	}
+
+	if len(s.Env) > 0 {
+		opts = append(opts, testcontainers.WithEnv(s.Env))
+	}
+
+	if len(s.Cmd) > 0 {
+		opts = append(opts, testcontainers.WithCmd(s.Cmd...))
+	}
+
+	if s.Config != "" {
+		withConfig, ok := configOptions[kind]
+		if !ok {
+			return nil, errors.Errorf("kind %q does not accept a config file", kind)
+		}
+
+		opts = append(opts, withConfig(s.Config))
+	}
+
+	return opts, nil
+}
+
+func printService(w io.Writer, svc common.Service) {
+	fmt.Fprintf(w, "%s (%s)\n", svc.ServiceName(), svc.Kind())
+
+	endpoints := svc.Endpoints()
+	for _, key := range slices.Sorted(maps.Keys(endpoints)) {
+		fmt.Fprintf(w, "  %-24s %s\n", key, endpoints[key])
+	}
+
+	env := svc.ConnectionEnv()
+	for _, key := range slices.Sorted(maps.Keys(env)) {
+		fmt.Fprintf(w, "  %s=%s\n", key, env[key])
+	}
+}
+
+// writeOutputs writes the dotenv file, starting with the session id, and the
+// JSON services document. Empty file names are skipped.
+func writeOutputs(envFile, jsonFile, session string, services []common.Service) error {
+	if envFile != "" {
+		var b bytes.Buffer
+
+		fmt.Fprintf(&b, "%s=%s\n", common.SessionEnv, strconv.Quote(session))
+
+		if err := common.WriteDotenv(&b, services...); err != nil {
+			return err
+		}
+
+		if err := os.WriteFile(envFile, b.Bytes(), 0o600); err != nil {
+			return err
+		}
+	}
+
+	if jsonFile != "" {
+		var b bytes.Buffer
+
+		if err := common.WriteJSON(&b, services...); err != nil {
+			return err
+		}
+
+		if err := os.WriteFile(jsonFile, b.Bytes(), 0o600); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkEnvFile refuses to overwrite an existing dotenv file that up did not
+// write, recognized by the session variable on its first line.
+func checkEnvFile(envFile string) error {
+	if envFile == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(envFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if len(data) == 0 || bytes.HasPrefix(data, []byte(common.SessionEnv+"=")) {
+		return nil
+	}
+
+	return errors.Errorf("%s was not written by up, pass another -env-file or -force to overwrite it", envFile)
+}
+
+func readSession(envFile string) (string, error) {
+	f, err := os.Open(envFile)
+	if err != nil {
+		return "", errors.Wrap(err, "-session is not set and dotenv file is not readable")
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(scanner.Text(), common.SessionEnv+"="); ok {
+			return strconv.Unquote(value)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+
+	return "", errors.Errorf("%s has no %s", envFile, common.SessionEnv)
+}
@@ -0,0 +1,230 @@
+package common
+
+import (
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	errors "github.com/go-faster/errors"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// ImagesEnv overrides catalog images. The value is either a comma separated
+// list of service=image pairs or the path to a YAML or JSON file mapping
+// services to images:
+//
+//	GOAT_IMAGES="postgres=postgres:16.4-alpine,redis=redis:7.4.1-alpine"
+//	GOAT_IMAGES=/path/to/images.yaml
+const ImagesEnv = "GOAT_IMAGES"
+
+type (
+	// Image is a container image reference split into its parts.
+	Image struct {
+		Repository string `yaml:"repository" json:"repository"`
+		Tag        string `yaml:"tag" json:"tag"`
+		Digest     string `yaml:"digest,omitempty" json:"digest,omitempty"`
+	}
+)
+
+// catalog lists the default image of every service.
+// Tags are pinned; digests are filled through ImagesEnv overrides.
+var catalog = map[string]Image{
+	"postgres":        {Repository: "postgres", Tag: "15.3-alpine3.18"},
+	"redis":           {Repository: "redis", Tag: "7.2.2-alpine"},
+	"clickhouse":      {Repository: "clickhouse/clickhouse-server", Tag: "23.8"},
+	"kafka":           {Repository: "confluentinc/confluent-local", Tag: "7.6.0"},
+	"s3":              {Repository: "localstack/localstack", Tag: "1.4.0"},
+	"minio":           {Repository: "minio/minio", Tag: "RELEASE.2024-10-13T13-34-11Z"},
+	"jaeger":          {Repository: "jaegertracing/all-in-one", Tag: "1.51"},
+	"victoriametrics": {Repository: "victoriametrics/victoria-metrics", Tag: "v1.103.0"},
+	"xray":            {Repository: "teddysun/xray", Tag: "1.8.24"},
+	"singbox":         {Repository: "ghcr.io/sagernet/sing-box", Tag: "v1.10.7"},
+
+	// postgres flavors shipping extensions the stock image lacks, same major version
+	"postgres-postgis":     {Repository: "postgis/postgis", Tag: "15-3.4-alpine"},
+	"postgres-pgvector":    {Repository: "pgvector/pgvector", Tag: "0.7.4-pg15"},
+	"postgres-timescaledb": {Repository: "timescale/timescaledb", Tag: "2.14.2-pg15"},
+
+	// sidecar of psql.WithPgBouncer
+	"pgbouncer": {Repository: "edoburu/pgbouncer", Tag: "v1.23.1-p2"},
+}
+
+var (
+	overridesOnce sync.Once
+	overrides     map[string]Image
+	overridesErr  error
+
+	catalogMu sync.RWMutex
+)
+
+// ParseImage splits an image reference of the form repository[:tag][@digest].
+func ParseImage(ref string) Image {
+	var img Image
+
+	ref, img.Digest, _ = strings.Cut(ref, "@")
+
+	// a colon after the last slash separates the tag, earlier ones belong to the registry port
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		ref, img.Tag = ref[:i], ref[i+1:]
+	}
+
+	img.Repository = ref
+
+	return img
+}
+
+// String returns the image reference. The digest, when present, pins the image
+// even if the tag has been moved.
+func (i Image) String() string {
+	ref := i.Repository
+	if i.Tag != "" {
+		ref += ":" + i.Tag
+	}
+
+	if i.Digest != "" {
+		ref += "@" + i.Digest
+	}
+
+	return ref
+}
+
+// CatalogImage returns the image of the service with overrides applied.
+func CatalogImage(service string) (Image, error) {
+	if err := loadOverrides(); err != nil {
+		return Image{}, err
+	}
+
+	catalogMu.RLock()
+	defer catalogMu.RUnlock()
+
+	if img, ok := overrides[service]; ok {
+		return img, nil
+	}
+
+	img, ok := catalog[service]
+	if !ok {
+		return Image{}, errors.Errorf("image catalog has no service %q", service)
+	}
+
+	return img, nil
+}
+
+// SetImage overrides the image of a service for the rest of the process.
+func SetImage(service string, img Image) {
+	_ = loadOverrides() //nolint:errcheck // explicit overrides win over a broken environment
+
+	catalogMu.Lock()
+	defer catalogMu.Unlock()
+
+	if overrides == nil {
+		overrides = map[string]Image{}
+	}
+
+	overrides[service] = img
+}
+
+// ResolveImage returns the image reference a service is started from, passed
+// through the mirror configuration.
+func ResolveImage(service string) (string, error) {
+	img, err := CatalogImage(service)
+	if err != nil {
+		return "", err
+	}
+
+	cfg, err := Mirrors()
+	if err != nil {
+		return "", err
+	}
+
+	return cfg.Resolve(img.String())
+}
+
+// Images returns the image references, as pulled, needed by the given
+// services or by all catalog services when none are given.
+func Images(services ...string) ([]string, error) {
+	if len(services) == 0 {
+		services = CatalogServices()
+	}
+
+	images := make([]string, 0, len(services))
+
+	for _, service := range services {
+		ref, err := ResolveImage(service)
+		if err != nil {
+			return nil, err
+		}
+
+		if !slices.Contains(images, ref) {
+			images = append(images, ref)
+		}
+	}
+
+	return images, nil
+}
+
+// CatalogServices returns the sorted names of all services in the catalog.
+func CatalogServices() []string {
+	catalogMu.RLock()
+	defer catalogMu.RUnlock()
+
+	return slices.Sorted(maps.Keys(catalog))
+}
+
+func loadOverrides() error {
+	overridesOnce.Do(func() {
+		value := os.Getenv(ImagesEnv)
+
+		catalogMu.Lock()
+		defer catalogMu.Unlock()
+
+		overrides, overridesErr = parseOverrides(value)
+
+		// SINGBOX_IMAGE predates the catalog and is kept for compatibility
+		if img := os.Getenv("SINGBOX_IMAGE"); img != "" && overridesErr == nil {
+			if _, ok := overrides["singbox"]; !ok {
+				overrides["singbox"] = ParseImage(img)
+			}
+		}
+	})
+
+	return overridesErr
+}
+
+func parseOverrides(value string) (map[string]Image, error) {
+	result := map[string]Image{}
+
+	if value == "" {
+		return result, nil
+	}
+
+	if !strings.Contains(value, "=") {
+		data, err := os.ReadFile(value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "read %s", ImagesEnv)
+		}
+
+		var refs map[string]string
+		if err := yaml.Unmarshal(data, &refs); err != nil {
+			return nil, errors.Wrapf(err, "parse %s", value)
+		}
+
+		for service, ref := range refs {
+			result[service] = ParseImage(ref)
+		}
+
+		return result, nil
+	}
+
+	for _, pair := range strings.Split(value, ",") {
+		service, ref, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || service == "" || ref == "" {
+			return nil, errors.Errorf("%s: invalid entry %q, expected service=image", ImagesEnv, pair)
+		}
+
+		result[service] = ParseImage(ref)
+	}
+
+	return result, nil
+}
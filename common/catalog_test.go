@@ -0,0 +1,113 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseImage(t *testing.T) {
+	tests := []struct {
+		ref      string
+		expected Image
+	}{
+		{"redis", Image{Repository: "redis"}},
+		{"redis:7.2.2-alpine", Image{Repository: "redis", Tag: "7.2.2-alpine"}},
+		{"localhost:5000/app", Image{Repository: "localhost:5000/app"}},
+		{"localhost:5000/app:dev", Image{Repository: "localhost:5000/app", Tag: "dev"}},
+		{"nginx:1.20@sha256:abc123", Image{Repository: "nginx", Tag: "1.20", Digest: "sha256:abc123"}},
+		{"ghcr.io/a/b@sha256:abc123", Image{Repository: "ghcr.io/a/b", Digest: "sha256:abc123"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			img := ParseImage(tt.ref)
+			if img != tt.expected {
+				t.Errorf("ParseImage(%q) = %+v, want %+v", tt.ref, img, tt.expected)
+			}
+
+			if img.String() != tt.ref {
+				t.Errorf("String() = %q, want %q", img.String(), tt.ref)
+			}
+		})
+	}
+}
+
+func TestParseOverrides(t *testing.T) {
+	inline, err := parseOverrides("postgres=postgres:16-alpine, redis=redis:7@sha256:abc")
+	if err != nil {
+		t.Fatalf("parseOverrides() error = %v", err)
+	}
+
+	if inline["postgres"].Tag != "16-alpine" || inline["redis"].Digest != "sha256:abc" {
+		t.Errorf("parseOverrides() = %+v", inline)
+	}
+
+	if _, err := parseOverrides("postgres="); err == nil {
+		t.Errorf("parseOverrides() expected error for empty image")
+	}
+
+	filename := filepath.Join(t.TempDir(), "images.yaml")
+	if err := os.WriteFile(filename, []byte("kafka: confluentinc/confluent-local:7.7.0\n"), 0o600); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+
+	fromFile, err := parseOverrides(filename)
+	if err != nil {
+		t.Fatalf("parseOverrides(file) error = %v", err)
+	}
+
+	if fromFile["kafka"].Tag != "7.7.0" {
+		t.Errorf("parseOverrides(file) = %+v", fromFile)
+	}
+}
+
+func TestCatalogIsPinned(t *testing.T) {
+	for service, img := range catalog {
+		if img.Tag == "" || img.Tag == "latest" {
+			t.Errorf("catalog image of %s is not pinned: %s", service, img)
+		}
+	}
+}
+
+func TestResolveImage(t *testing.T) {
+	t.Setenv("DOCKER_PROXY", "mirror.local:5000")
+
+	ref, err := ResolveImage("redis")
+	if err != nil {
+		t.Fatalf("ResolveImage() error = %v", err)
+	}
+
+	if want := "mirror.local:5000/" + catalog["redis"].String(); ref != want {
+		t.Errorf("ResolveImage() = %q, want %q", ref, want)
+	}
+
+	if _, err := ResolveImage("unknown"); err == nil {
+		t.Errorf("ResolveImage() of an unknown service expected error")
+	}
+
+	// an invalid mirror is an error instead of a panic
+	t.Setenv("DOCKER_PROXY", "://invalid-url")
+
+	if _, err := ResolveImage("redis"); err == nil {
+		t.Errorf("ResolveImage() with an invalid mirror expected error")
+	}
+}
+
+func TestResolveImageKeepsDigest(t *testing.T) {
+	t.Setenv("DOCKER_PROXY", "mirror.local:5000")
+
+	digest := "sha256:" + strings.Repeat("a", 64)
+
+	SetImage("digest-test", Image{Repository: "redis", Tag: "7.2.2-alpine", Digest: digest})
+
+	ref, err := ResolveImage("digest-test")
+	if err != nil {
+		t.Fatalf("ResolveImage() error = %v", err)
+	}
+
+	if want := "mirror.local:5000/redis:7.2.2-alpine@" + digest; ref != want {
+		t.Errorf("ResolveImage() = %q, want %q", ref, want)
+	}
+}
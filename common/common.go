@@ -2,73 +2,74 @@ package common
 
 import (
 	"fmt"
-	"net/url"
-	"os"
-	"strings"
+	"log/slog"
 
 	testcontainers "github.com/testcontainers/testcontainers-go"
 )
 
-var proxyTrimList = []string{
-	"docker.io/",
-	"ghcr.io/",
-}
-
 type (
+	// ImageSubstitutor rewrites images of testcontainers requests according
+	// to the mirror configuration, see Mirrors.
 	ImageSubstitutor struct {
-		proxy string
+		mirrors *MirrorConfig
+		err     error
+		logger  *slog.Logger
 	}
 )
 
+// DockerProxy returns the image reference to pull instead of u according to
+// the mirror configuration. It panics if the configuration is invalid.
 func DockerProxy(u string) string {
-	if proxy := os.Getenv("DOCKER_PROXY"); proxy != "" {
-		fmt.Println("proxy=", proxy)
-
-		for _, prefix := range proxyTrimList {
-			if strings.HasPrefix(u, prefix) {
-				u = strings.TrimPrefix(u, prefix)
-				fmt.Println("trimmed prefix", prefix, "from", u)
-			}
-		}
-
-		p, err := url.JoinPath(proxy, u)
-		if err != nil {
-			panic(err)
-		}
-
-		u = p
-		fmt.Println("target image ", p)
+	cfg, err := Mirrors()
+	if err != nil {
+		panic(err)
+	}
+
+	p, err := cfg.Resolve(u)
+	if err != nil {
+		panic(err)
+	}
+
+	if p != u {
+		Logger().Debug("substituted image", slog.String("source", u), slog.String("image", p))
 	}
 
-	return u
+	return p
 }
 
 func (a *ImageSubstitutor) Description() string {
-	m := "docker proxy substitutor %s"
-	if a.proxy != "" {
-		return fmt.Sprintf(m, fmt.Sprintf("to %s", a.proxy))
+	m := "docker mirror substitutor %s"
+	if a.mirrors.Enabled() {
+		return fmt.Sprintf(m, fmt.Sprintf("with %d mirrors", len(a.mirrors.Mirrors)))
 	}
 
 	return fmt.Sprintf(m, "is disabled")
 }
 
 func (a *ImageSubstitutor) Substitute(image string) (string, error) {
-	if a.proxy != "" && !strings.HasPrefix(image, a.proxy) {
-		p, err := url.JoinPath(a.proxy, image)
-		if err != nil {
-			return "", err
-		}
-
-		image = p
-		fmt.Println("target image ", image)
+	if a.err != nil {
+		return "", a.err
 	}
 
-	return image, nil
+	p, err := a.mirrors.Resolve(image)
+	if err != nil {
+		return "", err
+	}
+
+	if p != image {
+		a.logger.Debug("substituted image", slog.String("source", image), slog.String("image", p))
+	}
+
+	return p, nil
 }
 
 func NewImageSubstitutor() *ImageSubstitutor {
+	cfg, err := Mirrors()
+
 	return &ImageSubstitutor{
-		proxy: os.Getenv("DOCKER_PROXY"), // I don't want to change CI config, so let's keep env name
+		mirrors: cfg,
+		err:     err,
+		logger:  Logger(),
 	}
 }
 
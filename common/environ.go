@@ -0,0 +1,270 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+
+	errors "github.com/go-faster/errors"
+)
+
+type (
+	// Document describes started services in a form another process can read
+	// back, see WriteJSON and ReadJSON.
+	Document struct {
+		Services []ServiceRecord `json:"services"`
+	}
+
+	// ServiceRecord is one service of a Document.
+	ServiceRecord struct {
+		Name        string `json:"name"`
+		Kind        string `json:"kind"`
+		ContainerID string `json:"container_id,omitempty"`
+		// Fields holds the exported scalar fields of the service Env.
+		Fields map[string]json.RawMessage `json:"fields"`
+		// Env holds the connection variables of the service.
+		Env map[string]string `json:"env"`
+		// Sidecars and Networks are the ids of the containers and networks
+		// started along with the service, see Sidecars.
+		Sidecars []string `json:"sidecars,omitempty"`
+		Networks []string `json:"networks,omitempty"`
+	}
+
+	// Sidecars is implemented by services that start more containers or
+	// networks along with their own, e.g. psql.WithPgBouncer. Share records
+	// them, so the last release removes them even from a process that only
+	// attached to the service.
+	Sidecars interface {
+		Sidecars() (containers, networks []string)
+	}
+
+	prefixed struct {
+		Service
+		prefix string
+	}
+)
+
+// PrefixEnv returns env with prefix prepended to every variable name.
+// Service packages use it to implement EnvVars.
+func PrefixEnv(prefix string, env map[string]string) map[string]string {
+	if prefix == "" {
+		return env
+	}
+
+	result := make(map[string]string, len(env))
+	for key, value := range env {
+		result[prefix+key] = value
+	}
+
+	return result
+}
+
+// WithEnvPrefix returns the service with ConnectionEnv rendered with the
+// prefix, e.g. ORDERS_DATABASE_URL for prefix ORDERS_. Use it to export
+// several services of the same kind.
+func WithEnvPrefix(prefix string, svc Service) Service {
+	return &prefixed{Service: svc, prefix: prefix}
+}
+
+// ConnectionEnv implements Service.
+func (p *prefixed) ConnectionEnv() map[string]string {
+	return p.Service.EnvVars(p.prefix)
+}
+
+// EnvVars implements Service.
+func (p *prefixed) EnvVars(prefix string) map[string]string {
+	return p.Service.EnvVars(prefix + p.prefix)
+}
+
+// Unwrap returns the wrapped service.
+func (p *prefixed) Unwrap() Service {
+	return p.Service
+}
+
+// Environ returns the connection variables of the services as KEY=VALUE
+// pairs, ready for exec.Cmd.Env. Variables are sorted within a service and
+// services keep their order, so a later service wins on duplicate names.
+func Environ(services ...Service) []string {
+	var environ []string
+
+	for _, svc := range services {
+		env := svc.ConnectionEnv()
+		for _, key := range slices.Sorted(maps.Keys(env)) {
+			environ = append(environ, key+"="+env[key])
+		}
+	}
+
+	return environ
+}
+
+// WriteDotenv writes the connection variables of the services in .env format
+// with double quoted values.
+func WriteDotenv(w io.Writer, services ...Service) error {
+	for _, svc := range services {
+		env := svc.ConnectionEnv()
+		for _, key := range slices.Sorted(maps.Keys(env)) {
+			if _, err := fmt.Fprintf(w, "%s=%s\n", key, strconv.Quote(env[key])); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// WriteDotenvFile writes the connection variables of the services to a .env
+// file readable only by the owner, as it contains credentials.
+func WriteDotenvFile(filename string, services ...Service) error {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+
+	return errors.Join(WriteDotenv(f, services...), f.Close())
+}
+
+// WriteJSON writes a Document describing the services.
+func WriteJSON(w io.Writer, services ...Service) error {
+	doc := Document{Services: make([]ServiceRecord, 0, len(services))}
+
+	for _, svc := range services {
+		record, err := newServiceRecord(svc)
+		if err != nil {
+			return err
+		}
+
+		doc.Services = append(doc.Services, record)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(doc)
+}
+
+// ReadJSON reads a Document written by WriteJSON.
+func ReadJSON(r io.Reader) (*Document, error) {
+	var doc Document
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, errors.Wrap(err, "decode services document")
+	}
+
+	return &doc, nil
+}
+
+// Lookup returns the record of the named service.
+func (d *Document) Lookup(name string) (ServiceRecord, bool) {
+	for _, record := range d.Services {
+		if record.Name == name {
+			return record, true
+		}
+	}
+
+	return ServiceRecord{}, false
+}
+
+// Decode fills the exported fields of target, a pointer to a service Env,
+// from the record of the named service. The kinds must match. Service
+// packages wrap it in their Load function.
+func (d *Document) Decode(name string, target Service) error {
+	record, ok := d.Lookup(name)
+	if !ok {
+		return errors.Errorf("document has no service %q", name)
+	}
+
+	if record.Kind != target.Kind() {
+		return errors.Errorf("service %q is %s, not %s", name, record.Kind, target.Kind())
+	}
+
+	v := reflect.ValueOf(target)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return errors.Errorf("decode %q: target must be a pointer to a struct", name)
+	}
+
+	v = v.Elem()
+
+	for key, raw := range record.Fields {
+		field, ok := v.Type().FieldByName(key)
+		if !ok || !isRecordField(field) {
+			continue // written by a newer version, ignore
+		}
+
+		if err := json.Unmarshal(raw, v.FieldByIndex(field.Index).Addr().Interface()); err != nil {
+			return errors.Wrapf(err, "decode %q field %s", name, key)
+		}
+	}
+
+	return nil
+}
+
+func newServiceRecord(svc Service) (ServiceRecord, error) {
+	record := ServiceRecord{
+		Name:   svc.ServiceName(),
+		Kind:   svc.Kind(),
+		Fields: map[string]json.RawMessage{},
+		Env:    svc.ConnectionEnv(),
+	}
+
+	for {
+		wrapper, ok := svc.(interface{ Unwrap() Service })
+		if !ok {
+			break
+		}
+
+		svc = wrapper.Unwrap()
+	}
+
+	if sidecars, ok := svc.(Sidecars); ok {
+		record.Sidecars, record.Networks = sidecars.Sidecars()
+	}
+
+	v := reflect.Indirect(reflect.ValueOf(svc))
+	if v.Kind() != reflect.Struct {
+		return record, nil
+	}
+
+	// the embedded container is nil for Envs loaded from a document
+	if c := v.FieldByName("Container"); c.IsValid() && c.Kind() == reflect.Interface && !c.IsNil() {
+		if container, ok := c.Interface().(interface{ GetContainerID() string }); ok {
+			record.ContainerID = container.GetContainerID()
+		}
+	}
+
+	for _, field := range reflect.VisibleFields(v.Type()) {
+		if !isRecordField(field) {
+			continue
+		}
+
+		raw, err := json.Marshal(v.FieldByIndex(field.Index).Interface())
+		if err != nil {
+			return ServiceRecord{}, errors.Wrapf(err, "encode %s field %s", record.Name, field.Name)
+		}
+
+		record.Fields[field.Name] = raw
+	}
+
+	return record, nil
+}
+
+// isRecordField reports whether a struct field is stored in a ServiceRecord:
+// exported, not embedded, and of a scalar kind.
+func isRecordField(field reflect.StructField) bool {
+	if !field.IsExported() || field.Anonymous || len(field.Index) != 1 {
+		return false
+	}
+
+	switch field.Type.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	default:
+		return false
+	}
+}
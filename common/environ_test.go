@@ -0,0 +1,121 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	testcontainers "github.com/testcontainers/testcontainers-go"
+)
+
+type fakeService struct {
+	testcontainers.Container
+	Address string
+	Port    int
+
+	name string
+}
+
+func (f *fakeService) ServiceName() string               { return f.name }
+func (f *fakeService) Kind() string                      { return "fake" }
+func (f *fakeService) Endpoints() map[string]string      { return map[string]string{"main": f.Address} }
+func (f *fakeService) HealthCheck(context.Context) error { return nil }
+func (f *fakeService) ConnectionEnv() map[string]string  { return f.EnvVars("") }
+func (f *fakeService) EnvVars(prefix string) map[string]string {
+	return PrefixEnv(prefix, map[string]string{"FAKE_ADDR": f.Address, "FAKE_URL": "fake://" + f.Address})
+}
+
+func TestEnviron(t *testing.T) {
+	main := &fakeService{Address: "localhost:1", name: "main"}
+	other := &fakeService{Address: "localhost:2", name: "other"}
+
+	got := Environ(main, WithEnvPrefix("OTHER_", other))
+	expected := []string{
+		"FAKE_ADDR=localhost:1",
+		"FAKE_URL=fake://localhost:1",
+		"OTHER_FAKE_ADDR=localhost:2",
+		"OTHER_FAKE_URL=fake://localhost:2",
+	}
+
+	if !slices.Equal(got, expected) {
+		t.Errorf("Environ() = %v, want %v", got, expected)
+	}
+}
+
+func TestWriteDotenv(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteDotenv(&buf, &fakeService{Address: `a "quoted" host`}); err != nil {
+		t.Fatalf("WriteDotenv() error = %v", err)
+	}
+
+	expected := "FAKE_ADDR=\"a \\\"quoted\\\" host\"\nFAKE_URL=\"fake://a \\\"quoted\\\" host\"\n"
+	if buf.String() != expected {
+		t.Errorf("WriteDotenv() = %q, want %q", buf.String(), expected)
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+
+	svc := &fakeService{Address: "localhost:5432", Port: 5432, name: "db"}
+	if err := WriteJSON(&buf, WithEnvPrefix("DB_", svc)); err != nil {
+		t.Fatalf("WriteJSON() error = %v", err)
+	}
+
+	doc, err := ReadJSON(strings.NewReader(buf.String()))
+	if err != nil {
+		t.Fatalf("ReadJSON() error = %v", err)
+	}
+
+	record, ok := doc.Lookup("db")
+	if !ok {
+		t.Fatalf("record db is missing in %s", buf.String())
+	}
+
+	if record.Env["DB_FAKE_ADDR"] != "localhost:5432" {
+		t.Errorf("record env = %v", record.Env)
+	}
+
+	loaded := &fakeService{name: "db"}
+	if err := doc.Decode("db", loaded); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+
+	if loaded.Address != svc.Address || loaded.Port != svc.Port {
+		t.Errorf("Decode() = %+v, want %+v", loaded, svc)
+	}
+
+	if err := doc.Decode("missing", loaded); err == nil {
+		t.Errorf("Decode() of a missing service expected error")
+	}
+}
+
+type sidecarService struct {
+	fakeService
+}
+
+func (s *sidecarService) Sidecars() (containers, networks []string) {
+	return []string{"bouncer-id"}, []string{"network-id"}
+}
+
+func TestServiceRecordSidecars(t *testing.T) {
+	record, err := newServiceRecord(&sidecarService{fakeService{name: "db"}})
+	if err != nil {
+		t.Fatalf("newServiceRecord() error = %v", err)
+	}
+
+	if !slices.Equal(record.Sidecars, []string{"bouncer-id"}) || !slices.Equal(record.Networks, []string{"network-id"}) {
+		t.Errorf("record sidecars = %v, networks = %v", record.Sidecars, record.Networks)
+	}
+
+	plain, err := newServiceRecord(&fakeService{name: "db"})
+	if err != nil {
+		t.Fatalf("newServiceRecord() error = %v", err)
+	}
+
+	if plain.Sidecars != nil || plain.Networks != nil {
+		t.Errorf("record of a service without sidecars = %+v", plain)
+	}
+}
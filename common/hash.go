@@ -0,0 +1,145 @@
+package common
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+
+	errors "github.com/go-faster/errors"
+	testcontainers "github.com/testcontainers/testcontainers-go"
+)
+
+// hashLength is the number of hex digits of a RequestHash.
+const hashLength = 12
+
+type (
+	// HashKeyer is implemented by customizers whose effect is not visible in
+	// the request, e.g. migrations applied once the container runs. The key
+	// is part of RequestHash and of the WithReuse container name, an empty
+	// key leaves them unchanged.
+	HashKeyer interface {
+		HashKey() (string, error)
+	}
+
+	hashedFile struct {
+		Path    string `json:"path"`
+		Mode    int64  `json:"mode"`
+		Content string `json:"content,omitempty"`
+	}
+)
+
+// RequestHash returns a short stable hash of the service kind, its catalog
+// image and the request produced by the customizers: image, environment,
+// command, files (with host file contents), ports, networks, labels and tmpfs,
+// plus the keys of HashKeyer customizers. Other customizers whose effect is
+// outside these fields, such as wait strategies and lifecycle hooks, do not
+// change the hash.
+func RequestHash(kind string, opts ...testcontainers.ContainerCustomizer) (string, error) {
+	req := testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Env:            map[string]string{},
+			Labels:         map[string]string{},
+			NetworkAliases: map[string][]string{},
+		},
+	}
+
+	for _, opt := range opts {
+		if err := opt.Customize(&req); err != nil {
+			return "", errors.Wrap(err, "customize request")
+		}
+	}
+
+	return hashRequest(kind, &req, opts)
+}
+
+// hashKeys returns the keys of the HashKeyer customizers in order.
+func hashKeys(opts []testcontainers.ContainerCustomizer) ([]string, error) {
+	var keys []string
+
+	for _, opt := range opts {
+		if keyer, ok := opt.(HashKeyer); ok {
+			key, err := keyer.HashKey()
+			if err != nil {
+				return nil, errors.Wrapf(err, "hash key of %T", opt)
+			}
+
+			if key != "" {
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	return keys, nil
+}
+
+// hashRequest implements RequestHash for a request already customized by opts.
+func hashRequest(kind string, req *testcontainers.GenericContainerRequest, opts []testcontainers.ContainerCustomizer) (string, error) {
+	keys, err := hashKeys(opts)
+	if err != nil {
+		return "", err
+	}
+
+	image := req.Image
+	if image == "" {
+		if img, err := CatalogImage(kind); err == nil {
+			image = img.String()
+		}
+	}
+
+	files := make([]hashedFile, 0, len(req.Files))
+
+	for _, f := range req.Files {
+		file := hashedFile{Path: f.ContainerFilePath, Mode: f.FileMode}
+
+		if f.Reader == nil && f.HostFilePath != "" {
+			content, err := os.ReadFile(f.HostFilePath)
+			if err != nil {
+				return "", errors.Wrapf(err, "hash file %s", f.HostFilePath)
+			}
+
+			sum := sha256.Sum256(content)
+			file.Content = hex.EncodeToString(sum[:])
+		}
+
+		files = append(files, file)
+	}
+
+	// encoding/json sorts map keys, so the encoding is stable
+	data, err := json.Marshal(struct {
+		Kind           string
+		Image          string
+		Env            map[string]string
+		Cmd            []string
+		Entrypoint     []string
+		ExposedPorts   []string
+		Files          []hashedFile
+		Networks       []string
+		NetworkAliases map[string][]string
+		Labels         map[string]string
+		Tmpfs          map[string]string
+		Name           string
+		Keys           []string `json:",omitempty"`
+	}{
+		Kind:           kind,
+		Image:          image,
+		Env:            req.Env,
+		Cmd:            req.Cmd,
+		Entrypoint:     req.Entrypoint,
+		ExposedPorts:   req.ExposedPorts,
+		Files:          files,
+		Networks:       req.Networks,
+		NetworkAliases: req.NetworkAliases,
+		Labels:         req.Labels,
+		Tmpfs:          req.Tmpfs,
+		Name:           req.Name,
+		Keys:           keys,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:])[:hashLength], nil
+}
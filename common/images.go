@@ -0,0 +1,216 @@
+package common
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/jsonmessage"
+	errors "github.com/go-faster/errors"
+	testcontainers "github.com/testcontainers/testcontainers-go"
+)
+
+// EnsureImages makes sure the images of the given services (all catalog
+// services when none are given) are present locally, pulling missing ones
+// through the configured mirror. Call it before starting any service to
+// fail fast on network restricted runners.
+func EnsureImages(ctx context.Context, services ...string) error {
+	refs, err := Images(services...)
+	if err != nil {
+		return err
+	}
+
+	cli, err := newDockerClient()
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	var errs []error
+
+	for _, ref := range refs {
+		if _, err := cli.ImageInspect(ctx, ref); err == nil {
+			continue
+		} else if !client.IsErrNotFound(err) {
+			errs = append(errs, errors.Wrapf(err, "inspect image %s", ref))
+			continue
+		}
+
+		if err := pullImage(ctx, cli, ref); err != nil {
+			errs = append(errs, errors.Wrapf(err,
+				"image %s is not present and cannot be pulled, "+
+					"pull it with `goat-services images pull` or load a bundle with `goat-services images load`",
+				ref,
+			))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// PullImages pulls image references, using mirror credentials when configured.
+func PullImages(ctx context.Context, refs ...string) error {
+	cli, err := newDockerClient()
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	for _, ref := range refs {
+		if err := pullImage(ctx, cli, ref); err != nil {
+			return errors.Wrapf(err, "pull %s", ref)
+		}
+	}
+
+	return nil
+}
+
+// SaveImages writes the images to w as a `docker save` tarball.
+func SaveImages(ctx context.Context, w io.Writer, refs ...string) error {
+	cli, err := newDockerClient()
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	r, err := cli.ImageSave(ctx, refs)
+	if err != nil {
+		return errors.Wrap(err, "save images")
+	}
+	defer r.Close()
+
+	_, err = io.Copy(w, r)
+
+	return err
+}
+
+// LoadImages loads images from a `docker save` tarball.
+func LoadImages(ctx context.Context, r io.Reader) error {
+	cli, err := newDockerClient()
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	resp, err := cli.ImageLoad(ctx, r)
+	if err != nil {
+		return errors.Wrap(err, "load images")
+	}
+	defer resp.Body.Close()
+
+	return jsonmessage.DisplayJSONMessagesStream(resp.Body, io.Discard, 0, false, nil)
+}
+
+// LockImages returns the catalog images of the services with digests of the
+// locally present images. Missing images are pulled first. The result can be
+// written to the file referenced by GOAT_IMAGES to pin digests.
+func LockImages(ctx context.Context, services ...string) (map[string]Image, error) {
+	if len(services) == 0 {
+		services = CatalogServices()
+	}
+
+	if err := EnsureImages(ctx, services...); err != nil {
+		return nil, err
+	}
+
+	cli, err := newDockerClient()
+	if err != nil {
+		return nil, err
+	}
+	defer cli.Close()
+
+	cfg, err := Mirrors()
+	if err != nil {
+		return nil, err
+	}
+
+	locked := make(map[string]Image, len(services))
+
+	for _, service := range services {
+		img, err := CatalogImage(service)
+		if err != nil {
+			return nil, err
+		}
+
+		ref, err := cfg.Resolve(img.String())
+		if err != nil {
+			return nil, err
+		}
+
+		inspect, err := cli.ImageInspect(ctx, ref)
+		if err != nil {
+			return nil, errors.Wrapf(err, "inspect image %s", ref)
+		}
+
+		repository := ParseImage(ref).Repository
+		for _, repoDigest := range inspect.RepoDigests {
+			if repo, digest, ok := strings.Cut(repoDigest, "@"); ok && repo == repository {
+				img.Digest = digest
+				break
+			}
+		}
+
+		if img.Digest == "" {
+			return nil, errors.Errorf("image %s has no digest for %s", ref, repository)
+		}
+
+		locked[service] = img
+	}
+
+	return locked, nil
+}
+
+func pullImage(ctx context.Context, cli *client.Client, ref string) error {
+	opts := image.PullOptions{}
+
+	if auth, err := registryAuth(ctx, ref); err != nil {
+		return err
+	} else if auth != nil {
+		opts.RegistryAuth, err = registry.EncodeAuthConfig(*auth)
+		if err != nil {
+			return err
+		}
+	}
+
+	Logger().InfoContext(ctx, "pulling image", slog.String("image", ref))
+
+	r, err := cli.ImagePull(ctx, ref, opts)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	return jsonmessage.DisplayJSONMessagesStream(r, io.Discard, 0, false, nil)
+}
+
+// registryAuth returns mirror credentials for the image, falling back to
+// the credentials testcontainers would use.
+func registryAuth(ctx context.Context, ref string) (*registry.AuthConfig, error) {
+	cfg, err := Mirrors()
+	if err != nil {
+		return nil, err
+	}
+
+	if username, password, ok := cfg.Auth(ref); ok {
+		return &registry.AuthConfig{Username: username, Password: password}, nil
+	}
+
+	if _, auth, err := testcontainers.DockerImageAuth(ctx, ref); err == nil {
+		return &auth, nil
+	}
+
+	return nil, nil
+}
+
+func newDockerClient() (*client.Client, error) {
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create docker client")
+	}
+
+	return cli, nil
+}
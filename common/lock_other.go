@@ -0,0 +1,47 @@
+//go:build !unix
+
+package common
+
+import (
+	"context"
+	"os"
+	"time"
+
+	errors "github.com/go-faster/errors"
+)
+
+const (
+	lockPollInterval = 50 * time.Millisecond
+
+	// staleLockAge is the age after which a lock file left by a killed
+	// process is removed, as there is no advisory locking to release it.
+	staleLockAge = 10 * time.Minute
+)
+
+// lockFile takes an exclusive lock by creating the file, waiting until it is
+// removed by the holder or ctx is done.
+func lockFile(ctx context.Context, path string) (func() error, error) {
+	for {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o600)
+		if err == nil {
+			return func() error {
+				return errors.Join(f.Close(), os.Remove(path))
+			}, nil
+		}
+
+		if !errors.Is(err, os.ErrExist) {
+			return nil, errors.Wrapf(err, "lock %s", path)
+		}
+
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > staleLockAge {
+			_ = os.Remove(path) //nolint:errcheck // another process may have removed it first
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
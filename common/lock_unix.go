@@ -0,0 +1,53 @@
+//go:build unix
+
+package common
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"time"
+
+	errors "github.com/go-faster/errors"
+)
+
+const lockPollInterval = 50 * time.Millisecond
+
+// lockFile takes an exclusive advisory lock on the file, waiting until it is
+// free or ctx is done. The lock is released when the process dies.
+func lockFile(ctx context.Context, path string) (func() error, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+
+		if !errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, errors.Join(errors.Wrapf(err, "lock %s", path), f.Close())
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, errors.Join(ctx.Err(), f.Close())
+		case <-time.After(lockPollInterval):
+		}
+	}
+
+	return func() error {
+		return errors.Join(syscall.Flock(int(f.Fd()), syscall.LOCK_UN), f.Close())
+	}, nil
+}
+
+// processLookup reports whether processAlive can tell exited processes apart.
+const processLookup = true
+
+// processAlive reports whether a process with the pid exists.
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,81 @@
+package common
+
+import (
+	"bytes"
+	"log/slog"
+	"sync/atomic"
+)
+
+type (
+	// TB is the part of testing.TB used by the test helpers of the module,
+	// so that importing it does not link the testing package into binaries.
+	TB interface {
+		Helper()
+		Logf(format string, args ...any)
+		Errorf(format string, args ...any)
+		Fatalf(format string, args ...any)
+		Cleanup(f func())
+	}
+)
+
+var logger atomic.Pointer[slog.Logger]
+
+func init() {
+	logger.Store(slog.New(slog.DiscardHandler))
+}
+
+// SetLogger sets the logger used by all packages of the module.
+// By default, nothing is logged. Passing nil restores the default.
+func SetLogger(l *slog.Logger) {
+	if l == nil {
+		l = slog.New(slog.DiscardHandler)
+	}
+
+	logger.Store(l)
+}
+
+// Logger returns the logger set by SetLogger.
+func Logger() *slog.Logger {
+	return logger.Load()
+}
+
+// WithLogger sets the logger for a single Run call, overriding the one set by SetLogger.
+// Passing nil uses the one set by SetLogger.
+func WithLogger(l *slog.Logger) RunOption {
+	return func(s *Settings) {
+		if l == nil {
+			l = Logger()
+		}
+
+		s.Logger = l
+	}
+}
+
+// TestLogger returns a logger that writes through tb.Logf, so messages are
+// attributed to the test that started the service and shown only when it fails
+// or with go test -v.
+func TestLogger(tb TB) *slog.Logger {
+	return slog.New(slog.NewTextHandler(tbWriter{tb: tb}, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			// tb.Logf already reports the time
+			if len(groups) == 0 && a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+
+			return a
+		},
+	}))
+}
+
+// tbWriter forwards every record written by slog.TextHandler to tb.Logf.
+type tbWriter struct {
+	tb TB
+}
+
+func (w tbWriter) Write(p []byte) (int, error) {
+	w.tb.Helper()
+	w.tb.Logf("%s", bytes.TrimSuffix(p, []byte("\n")))
+
+	return len(p), nil
+}
@@ -0,0 +1,359 @@
+package common
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	errors "github.com/go-faster/errors"
+	yaml "gopkg.in/yaml.v3"
+)
+
+const (
+	// MirrorsEnv points to a YAML or JSON file with the mirror configuration.
+	MirrorsEnv = "GOAT_MIRRORS"
+	// ProxyEnv is the legacy single proxy prefix, used when MirrorsEnv is not set.
+	ProxyEnv = "DOCKER_PROXY"
+
+	dockerAuthConfigEnv = "DOCKER_AUTH_CONFIG"
+
+	// defaultRegistry is assumed for images without a registry host.
+	defaultRegistry = "docker.io"
+	// anyRegistry is the registry of the fallback mirror rule.
+	anyRegistry = "*"
+)
+
+type (
+	// MirrorConfig maps source registries to mirrors.
+	//
+	// Example:
+	//
+	//	mirrors:
+	//	  - registry: docker.io
+	//	    mirror: mirror.example.com/dockerhub
+	//	    rewrite:
+	//	      - match: "^library/"
+	//	        replace: ""
+	//	  - registry: ghcr.io
+	//	    mirror: ghcr.mirror.example.com
+	//	    username: ci
+	//	    password_env: GHCR_MIRROR_PASSWORD
+	//	no_mirror:
+	//	  - localhost:5000
+	//	  - registry.example.com/team/*
+	MirrorConfig struct {
+		Mirrors  []Mirror `yaml:"mirrors" json:"mirrors"`
+		NoMirror []string `yaml:"no_mirror" json:"no_mirror"`
+	}
+
+	// Mirror describes where images of one source registry are pulled from.
+	Mirror struct {
+		// Registry is the source registry host, e.g. docker.io. The "*" rule
+		// applies to registries without own rule and keeps their host in the path.
+		Registry string `yaml:"registry" json:"registry"`
+		// Mirror is the mirror prefix, host with optional path.
+		Mirror string `yaml:"mirror" json:"mirror"`
+		// Rewrite rules are applied in order to the image path without registry.
+		Rewrite []RewriteRule `yaml:"rewrite" json:"rewrite"`
+
+		Username    string `yaml:"username" json:"username"`
+		Password    string `yaml:"password" json:"password"`
+		PasswordEnv string `yaml:"password_env" json:"password_env"`
+	}
+
+	// RewriteRule replaces matches of a regular expression in the image path.
+	RewriteRule struct {
+		Match   string `yaml:"match" json:"match"`
+		Replace string `yaml:"replace" json:"replace"`
+
+		re *regexp.Regexp
+	}
+)
+
+var (
+	mirrorsMu     sync.Mutex
+	mirrors       *MirrorConfig
+	mirrorsErr    error
+	mirrorsLoaded bool
+)
+
+// LoadMirrorConfig reads a mirror configuration from a YAML or JSON file.
+func LoadMirrorConfig(filename string) (*MirrorConfig, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "read mirror config")
+	}
+
+	var cfg MirrorConfig
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, errors.Wrapf(err, "parse mirror config %s", filename)
+	}
+
+	if err := cfg.compile(); err != nil {
+		return nil, errors.Wrapf(err, "mirror config %s", filename)
+	}
+
+	return &cfg, nil
+}
+
+// ProxyMirrorConfig returns the configuration equivalent to the legacy
+// DOCKER_PROXY behavior: docker.io and ghcr.io hosts are trimmed, images
+// of other registries are prefixed with the proxy as is.
+func ProxyMirrorConfig(proxy string) *MirrorConfig {
+	if proxy == "" {
+		return &MirrorConfig{}
+	}
+
+	// older releases accepted a URL, image references have no scheme
+	if scheme, host, ok := strings.Cut(proxy, "://"); ok && scheme != "" && strings.Trim(strings.ToLower(scheme), "abcdefghijklmnopqrstuvwxyz") == "" {
+		Logger().Warn("DOCKER_PROXY has a scheme, using it without", slog.String("proxy", proxy), slog.String("mirror", host))
+		proxy = host
+	}
+
+	return &MirrorConfig{
+		Mirrors: []Mirror{
+			{Registry: "docker.io", Mirror: proxy},
+			{Registry: "ghcr.io", Mirror: proxy},
+			{Registry: anyRegistry, Mirror: proxy},
+		},
+	}
+}
+
+// SetMirrors replaces the mirror configuration used by DockerProxy and
+// ImageSubstitutor. Passing nil makes it load from the environment again.
+func SetMirrors(cfg *MirrorConfig) error {
+	if cfg != nil {
+		if err := cfg.compile(); err != nil {
+			return err
+		}
+	}
+
+	mirrorsMu.Lock()
+	defer mirrorsMu.Unlock()
+
+	mirrors, mirrorsErr, mirrorsLoaded = cfg, nil, cfg != nil
+
+	if cfg != nil {
+		return cfg.exportCredentials()
+	}
+
+	return nil
+}
+
+// Mirrors returns the current mirror configuration. Unless set by SetMirrors,
+// it is loaded from the file in GOAT_MIRRORS, falling back to DOCKER_PROXY.
+func Mirrors() (*MirrorConfig, error) {
+	mirrorsMu.Lock()
+	defer mirrorsMu.Unlock()
+
+	if mirrorsLoaded {
+		return mirrors, mirrorsErr
+	}
+
+	if filename := os.Getenv(MirrorsEnv); filename != "" {
+		mirrors, mirrorsErr = LoadMirrorConfig(filename)
+		if mirrorsErr == nil {
+			mirrorsErr = mirrors.exportCredentials()
+		}
+
+		mirrorsLoaded = true
+
+		return mirrors, mirrorsErr
+	}
+
+	// DOCKER_PROXY is read on every call to keep the legacy behavior
+	// of picking up changes to the variable.
+	return ProxyMirrorConfig(os.Getenv(ProxyEnv)), nil
+}
+
+// Resolve returns the image reference to pull instead of image.
+func (c *MirrorConfig) Resolve(image string) (string, error) {
+	if c == nil || c.excluded(image) {
+		return image, nil
+	}
+
+	for _, m := range c.Mirrors {
+		if m.Mirror != "" && strings.HasPrefix(image, strings.TrimSuffix(m.Mirror, "/")+"/") {
+			// already points to a mirror
+			return image, nil
+		}
+	}
+
+	registry, rest := splitRegistry(image)
+
+	m := c.mirrorFor(registry)
+	if m == nil || m.Mirror == "" {
+		return image, nil
+	}
+
+	if m.Registry == anyRegistry {
+		rest = image
+	}
+
+	for _, rule := range m.Rewrite {
+		rest = rule.re.ReplaceAllString(rest, rule.Replace)
+	}
+
+	// a mirror is host[:port][/path]; url.JoinPath would read host:port as a scheme
+	if strings.Contains(m.Mirror, "://") {
+		return "", errors.Errorf("mirror %q must be host[:port][/path] without a scheme", m.Mirror)
+	}
+
+	return strings.TrimSuffix(m.Mirror, "/") + "/" + rest, nil
+}
+
+// Auth returns the credentials of the mirror an already resolved image is pulled from.
+func (c *MirrorConfig) Auth(resolved string) (username, password string, ok bool) {
+	if c == nil {
+		return "", "", false
+	}
+
+	for _, m := range c.Mirrors {
+		if m.Username == "" || !strings.HasPrefix(resolved, strings.TrimSuffix(m.Mirror, "/")+"/") {
+			continue
+		}
+
+		return m.Username, m.password(), true
+	}
+
+	return "", "", false
+}
+
+// Enabled reports whether at least one mirror is configured.
+func (c *MirrorConfig) Enabled() bool {
+	return c != nil && len(c.Mirrors) > 0
+}
+
+func (c *MirrorConfig) mirrorFor(registry string) *Mirror {
+	var fallback *Mirror
+
+	for i := range c.Mirrors {
+		switch c.Mirrors[i].Registry {
+		case registry:
+			return &c.Mirrors[i]
+		case anyRegistry:
+			fallback = &c.Mirrors[i]
+		}
+	}
+
+	return fallback
+}
+
+// excluded reports whether the image matches the no_mirror allowlist.
+// An entry matches a registry host, an image path prefix or a glob pattern.
+func (c *MirrorConfig) excluded(image string) bool {
+	registry, _ := splitRegistry(image)
+
+	for _, pattern := range c.NoMirror {
+		if pattern == registry || strings.HasPrefix(image, strings.TrimSuffix(pattern, "/")+"/") {
+			return true
+		}
+
+		if ok, _ := path.Match(pattern, image); ok { //nolint:errcheck // malformed patterns never match
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *MirrorConfig) compile() error {
+	for i := range c.Mirrors {
+		m := &c.Mirrors[i]
+		if m.Registry == "" {
+			return errors.Errorf("mirror %d: registry is required", i)
+		}
+
+		for j := range m.Rewrite {
+			re, err := regexp.Compile(m.Rewrite[j].Match)
+			if err != nil {
+				return errors.Wrapf(err, "mirror %s: rewrite rule %d", m.Registry, j)
+			}
+
+			m.Rewrite[j].re = re
+		}
+	}
+
+	return nil
+}
+
+// exportCredentials adds mirror credentials to DOCKER_AUTH_CONFIG, where
+// testcontainers looks for registry credentials. Existing entries win.
+func (c *MirrorConfig) exportCredentials() error {
+	type authEntry struct {
+		Auth string `json:"auth"`
+	}
+
+	var cfg struct {
+		Auths map[string]json.RawMessage `json:"auths"`
+	}
+
+	if env := os.Getenv(dockerAuthConfigEnv); env != "" {
+		if err := json.Unmarshal([]byte(env), &cfg); err != nil {
+			return errors.Wrapf(err, "parse %s", dockerAuthConfigEnv)
+		}
+	}
+
+	if cfg.Auths == nil {
+		cfg.Auths = map[string]json.RawMessage{}
+	}
+
+	changed := false
+
+	for _, m := range c.Mirrors {
+		if m.Username == "" {
+			continue
+		}
+
+		// the mirror is always a registry host, even without a dot, e.g. localhost:5000
+		host, _, _ := strings.Cut(m.Mirror, "/")
+		if _, ok := cfg.Auths[host]; ok {
+			continue
+		}
+
+		entry, err := json.Marshal(authEntry{
+			Auth: base64.StdEncoding.EncodeToString([]byte(m.Username + ":" + m.password())),
+		})
+		if err != nil {
+			return err
+		}
+
+		cfg.Auths[host] = entry
+		changed = true
+	}
+
+	if !changed {
+		return nil
+	}
+
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+
+	return os.Setenv(dockerAuthConfigEnv, string(data))
+}
+
+func (m *Mirror) password() string {
+	if m.PasswordEnv != "" {
+		return os.Getenv(m.PasswordEnv)
+	}
+
+	return m.Password
+}
+
+// splitRegistry splits an image reference into registry host and path.
+// References without a registry host belong to docker.io.
+func splitRegistry(image string) (registry, rest string) {
+	first, rest, found := strings.Cut(image, "/")
+	if found && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		return first, rest
+	}
+
+	return defaultRegistry, image
+}
@@ -0,0 +1,179 @@
+package common
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMirrorConfigResolve(t *testing.T) {
+	cfg := &MirrorConfig{
+		Mirrors: []Mirror{
+			{
+				Registry: "docker.io",
+				Mirror:   "hub.mirror.local/dockerhub",
+				Rewrite:  []RewriteRule{{Match: "^library/", Replace: ""}},
+			},
+			{Registry: "ghcr.io", Mirror: "ghcr.mirror.local"},
+		},
+		NoMirror: []string{"localhost:5000", "quay.io/internal/*"},
+	}
+	if err := cfg.compile(); err != nil {
+		t.Fatalf("compile() error = %v", err)
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"redis:7.2.2-alpine", "hub.mirror.local/dockerhub/redis:7.2.2-alpine"},
+		{"docker.io/library/postgres:15", "hub.mirror.local/dockerhub/postgres:15"},
+		{"ghcr.io/sagernet/sing-box:v1.10.0", "ghcr.mirror.local/sagernet/sing-box:v1.10.0"},
+		{"quay.io/other/image:1", "quay.io/other/image:1"},
+		{"quay.io/internal/image:1", "quay.io/internal/image:1"},
+		{"localhost:5000/app:dev", "localhost:5000/app:dev"},
+		{"hub.mirror.local/dockerhub/redis:7", "hub.mirror.local/dockerhub/redis:7"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			result, err := cfg.Resolve(tt.input)
+			if err != nil {
+				t.Fatalf("Resolve(%q) error = %v", tt.input, err)
+			}
+
+			if result != tt.expected {
+				t.Errorf("Resolve(%q) = %q, want %q", tt.input, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestMirrorConfigResolveWithPort(t *testing.T) {
+	tests := []struct {
+		mirror   string
+		input    string
+		expected string
+	}{
+		{"localhost:5000", "redis:7", "localhost:5000/redis:7"},
+		{"localhost:5000/", "ghcr.io/a/b:1", "localhost:5000/a/b:1"},
+		{"nexus.corp:8443/docker", "redis:7", "nexus.corp:8443/docker/redis:7"},
+		{"nexus.corp:8443/docker", "nexus.corp:8443/docker/redis:7", "nexus.corp:8443/docker/redis:7"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mirror+" "+tt.input, func(t *testing.T) {
+			result, err := ProxyMirrorConfig(tt.mirror).Resolve(tt.input)
+			if err != nil {
+				t.Fatalf("Resolve(%q) error = %v", tt.input, err)
+			}
+
+			if result != tt.expected {
+				t.Errorf("Resolve(%q) = %q, want %q", tt.input, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestExportCredentialsMirrorHost(t *testing.T) {
+	t.Setenv(dockerAuthConfigEnv, "")
+
+	cfg := &MirrorConfig{Mirrors: []Mirror{
+		{Registry: "docker.io", Mirror: "localhost:5000/hub", Username: "ci", Password: "secret"},
+	}}
+
+	if err := cfg.exportCredentials(); err != nil {
+		t.Fatalf("exportCredentials() error = %v", err)
+	}
+
+	var auth struct {
+		Auths map[string]json.RawMessage `json:"auths"`
+	}
+	if err := json.Unmarshal([]byte(os.Getenv(dockerAuthConfigEnv)), &auth); err != nil {
+		t.Fatalf("parse %s: %v", dockerAuthConfigEnv, err)
+	}
+
+	if _, ok := auth.Auths["localhost:5000"]; !ok || len(auth.Auths) != 1 {
+		t.Errorf("exported auths = %v, want localhost:5000", auth.Auths)
+	}
+}
+
+func TestImageSubstitutorMatchesDockerProxy(t *testing.T) {
+	t.Setenv(MirrorsEnv, "")
+	t.Setenv(ProxyEnv, "my-registry.com")
+
+	for _, image := range []string{"redis:7", "docker.io/redis:7", "ghcr.io/a/b:1", "quay.io/a/b:1"} {
+		substituted, err := NewImageSubstitutor().Substitute(image)
+		if err != nil {
+			t.Fatalf("Substitute(%q) error = %v", image, err)
+		}
+
+		if proxied := DockerProxy(image); substituted != proxied {
+			t.Errorf("Substitute(%q) = %q, DockerProxy = %q", image, substituted, proxied)
+		}
+	}
+}
+
+func TestLoadMirrorConfig(t *testing.T) {
+	t.Setenv(dockerAuthConfigEnv, "")
+	t.Setenv("TEST_MIRROR_PASSWORD", "secret")
+
+	filename := filepath.Join(t.TempDir(), "mirrors.yaml")
+	content := `
+mirrors:
+  - registry: docker.io
+    mirror: hub.mirror.local
+    username: ci
+    password_env: TEST_MIRROR_PASSWORD
+no_mirror:
+  - localhost
+`
+	if err := os.WriteFile(filename, []byte(content), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+
+	t.Setenv(MirrorsEnv, filename)
+	t.Cleanup(func() { _ = SetMirrors(nil) }) //nolint:errcheck // reset only
+
+	if err := SetMirrors(nil); err != nil {
+		t.Fatalf("SetMirrors(nil) error = %v", err)
+	}
+
+	cfg, err := Mirrors()
+	if err != nil {
+		t.Fatalf("Mirrors() error = %v", err)
+	}
+
+	if image := DockerProxy("redis:7"); image != "hub.mirror.local/redis:7" {
+		t.Errorf("DockerProxy() = %q", image)
+	}
+
+	if user, pass, ok := cfg.Auth("hub.mirror.local/redis:7"); !ok || user != "ci" || pass != "secret" {
+		t.Errorf("Auth() = %q, %q, %v", user, pass, ok)
+	}
+
+	var auth struct {
+		Auths map[string]struct {
+			Auth string `json:"auth"`
+		} `json:"auths"`
+	}
+	if err := json.Unmarshal([]byte(os.Getenv(dockerAuthConfigEnv)), &auth); err != nil {
+		t.Fatalf("parse %s: %v", dockerAuthConfigEnv, err)
+	}
+
+	if _, ok := auth.Auths["hub.mirror.local"]; !ok {
+		t.Errorf("credentials are not exported to %s", dockerAuthConfigEnv)
+	}
+}
+
+func TestProxyMirrorConfigStripsScheme(t *testing.T) {
+	result, err := ProxyMirrorConfig("https://mirror.example/").Resolve("redis:7")
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+
+	if want := "mirror.example/redis:7"; result != want {
+		t.Errorf("Resolve() = %q, want %q", result, want)
+	}
+}
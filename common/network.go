@@ -0,0 +1,46 @@
+package common
+
+import (
+	"net"
+
+	testcontainers "github.com/testcontainers/testcontainers-go"
+)
+
+// WithNetworkAlias attaches the container to an existing user defined network
+// and makes it reachable there under the given alias. Run functions fill the
+// Internal* fields of their Env from this alias, so other containers on the
+// same network can connect to the service as alias:containerPort.
+func WithNetworkAlias(networkName, alias string) testcontainers.CustomizeRequestOption {
+	return func(req *testcontainers.GenericContainerRequest) error {
+		req.Networks = append(req.Networks, networkName)
+
+		if req.NetworkAliases == nil {
+			req.NetworkAliases = make(map[string][]string)
+		}
+
+		req.NetworkAliases[networkName] = append(req.NetworkAliases[networkName], alias)
+
+		return nil
+	}
+}
+
+// NetworkAlias returns the first alias of the container on the first network
+// that has one, or an empty string if the container has no network alias.
+func NetworkAlias(req *testcontainers.GenericContainerRequest) string {
+	for _, nw := range req.Networks {
+		if aliases := req.NetworkAliases[nw]; len(aliases) > 0 {
+			return aliases[0]
+		}
+	}
+
+	return ""
+}
+
+// InternalAddress returns alias:port, or an empty string when alias is empty.
+func InternalAddress(alias, port string) string {
+	if alias == "" {
+		return ""
+	}
+
+	return net.JoinHostPort(alias, port)
+}
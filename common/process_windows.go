@@ -0,0 +1,36 @@
+package common
+
+import (
+	"syscall"
+
+	errors "github.com/go-faster/errors"
+)
+
+const (
+	// processQueryLimitedInformation is enough to read the exit code.
+	processQueryLimitedInformation = 0x1000
+
+	// stillActive is the exit code of a running process.
+	stillActive = 259
+)
+
+// processLookup reports whether processAlive can tell exited processes apart.
+const processLookup = true
+
+// processAlive reports whether a process with the pid exists. A handle may
+// outlive the process, so the exit code is checked as well.
+func processAlive(pid int) bool {
+	h, err := syscall.OpenProcess(processQueryLimitedInformation, false, uint32(pid))
+	if err != nil {
+		// the process exists but belongs to another user
+		return errors.Is(err, syscall.ERROR_ACCESS_DENIED)
+	}
+	defer syscall.CloseHandle(h) //nolint:errcheck // nothing to do on failure
+
+	var code uint32
+	if err := syscall.GetExitCodeProcess(h, &code); err != nil {
+		return true
+	}
+
+	return code == stillActive
+}
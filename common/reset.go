@@ -0,0 +1,55 @@
+package common
+
+import (
+	"context"
+	"io"
+	"strings"
+
+	errors "github.com/go-faster/errors"
+	testcontainers "github.com/testcontainers/testcontainers-go"
+	tcexec "github.com/testcontainers/testcontainers-go/exec"
+)
+
+type (
+	// Resetter is implemented by the Env of services holding state that can be
+	// cleared without restarting the container, so a reused or shared
+	// container starts every run empty.
+	Resetter interface {
+		Reset(ctx context.Context) error
+	}
+)
+
+// Reset resets the services implementing Resetter and skips the others.
+func Reset(ctx context.Context, services ...Service) error {
+	var errs []error
+
+	for _, svc := range services {
+		if r, ok := svc.(Resetter); ok {
+			if err := r.Reset(ctx); err != nil {
+				errs = append(errs, errors.Wrapf(err, "reset %s", svc.ServiceName()))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Exec runs a command in the container and returns its combined output.
+// A non-zero exit code is returned as an error with the output.
+func Exec(ctx context.Context, container testcontainers.Container, cmd ...string) (string, error) {
+	code, r, err := container.Exec(ctx, cmd, tcexec.Multiplexed())
+	if err != nil {
+		return "", errors.Wrapf(err, "exec %s", cmd[0])
+	}
+
+	out, err := io.ReadAll(r)
+	if err != nil {
+		return "", errors.Wrapf(err, "read output of %s", cmd[0])
+	}
+
+	if code != 0 {
+		return string(out), errors.Errorf("%s exited with code %d: %s", strings.Join(cmd, " "), code, strings.TrimSpace(string(out)))
+	}
+
+	return string(out), nil
+}
@@ -0,0 +1,234 @@
+package common
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"reflect"
+
+	errors "github.com/go-faster/errors"
+	testcontainers "github.com/testcontainers/testcontainers-go"
+)
+
+// logTailSize limits the amount of container logs attached to errors.
+const logTailSize = 2000
+
+// logsError attaches container logs to an error message.
+type logsError struct {
+	err  error
+	logs string
+}
+
+func (e *logsError) Error() string {
+	return e.err.Error() + "\nContainer logs:\n" + e.logs
+}
+
+func (e *logsError) Unwrap() error {
+	return e.err
+}
+
+type (
+	// Settings describe how a service is run, as opposed to the container
+	// request that describes the container itself.
+	Settings struct {
+		// Service is the service kind used in errors and log attributes.
+		Service string
+		// Logger already carries the service attribute.
+		Logger *slog.Logger
+		// Reuse keeps the container between runs, see WithReuse.
+		Reuse bool
+	}
+
+	// RunOption is a customizer that changes Settings instead of the container
+	// request. It can be passed to any Run function along with regular customizers.
+	RunOption func(*Settings)
+)
+
+// Customize implements testcontainers.ContainerCustomizer, RunOption does not
+// change the request.
+func (o RunOption) Customize(*testcontainers.GenericContainerRequest) error {
+	return nil
+}
+
+// WithReuse makes Run attach to a running container started earlier with the
+// same image and customizations instead of starting a new one. The container
+// is named goat-<kind>-<RequestHash>, the Env is filled from it as usual, so
+// mapped ports are re-read. Call Reset to clear the state left by the
+// previous run, and do not Terminate the container to keep it for the next
+// one. Reuse across runs requires TESTCONTAINERS_RYUK_DISABLED=true, as the
+// reaper removes the container when the process exits. The network names are
+// part of the hash, so services of a stack, whose network is created on every
+// run, are never reused.
+func WithReuse() RunOption {
+	return func(s *Settings) {
+		s.Reuse = true
+	}
+}
+
+// ReuseName returns the container name used by WithReuse for the request
+// customized by opts.
+func ReuseName(kind string, req *testcontainers.GenericContainerRequest, opts ...testcontainers.ContainerCustomizer) (string, error) {
+	hash, err := hashRequest(kind, req, opts)
+	if err != nil {
+		return "", err
+	}
+
+	return "goat-" + kind + "-" + hash, nil
+}
+
+// ModuleOptions returns customizers carrying the request fields set by
+// Customize into the Run function of a testcontainers module, which builds
+// its own request.
+func ModuleOptions(req *testcontainers.GenericContainerRequest) []testcontainers.ContainerCustomizer {
+	opts := []testcontainers.ContainerCustomizer{
+		testcontainers.WithImageSubstitutors(req.ImageSubstitutors...),
+		testcontainers.WithLabels(req.Labels),
+	}
+
+	if req.Reuse {
+		opts = append(opts, testcontainers.WithReuseByName(req.Name))
+	}
+
+	return opts
+}
+
+// Customize applies opts to req and collects the run settings. It returns the
+// first customizer error wrapped with the service name.
+func Customize(service string, req *testcontainers.GenericContainerRequest, opts ...testcontainers.ContainerCustomizer) (*Settings, error) {
+	settings := &Settings{
+		Service: service,
+		Logger:  Logger(),
+	}
+
+	for _, opt := range opts {
+		if apply, ok := opt.(RunOption); ok {
+			apply(settings)
+		}
+
+		if err := opt.Customize(req); err != nil {
+			return nil, errors.Wrapf(err, "%s: customize request", service)
+		}
+	}
+
+	settings.Logger = settings.Logger.With(slog.String("service", service))
+
+	if settings.Reuse {
+		name, err := ReuseName(service, req, opts...)
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s: reuse name", service)
+		}
+
+		req.Name = name
+		req.Reuse = true
+
+		if !testcontainers.ReadConfig().RyukDisabled {
+			settings.Logger.Warn("reused container is removed by the reaper when the process exits, set TESTCONTAINERS_RYUK_DISABLED=true")
+		}
+	}
+
+	// added after the reuse name, as the created label differs on every run
+	labels := SessionLabels()
+	labels[KindLabel] = service
+
+	if settings.Reuse {
+		labels[OwnerLabel] = DetachedOwner
+	}
+
+	if req.Labels == nil {
+		req.Labels = map[string]string{}
+	}
+
+	for key, value := range labels {
+		if _, ok := req.Labels[key]; !ok {
+			req.Labels[key] = value
+		}
+	}
+
+	for _, substitutor := range req.ImageSubstitutors {
+		if s, ok := substitutor.(*ImageSubstitutor); ok {
+			s.logger = settings.Logger
+		}
+	}
+
+	return settings, nil
+}
+
+// Start is the scaffold shared by the Run functions of all service packages.
+// It starts the container with start and then reads connection details with setup.
+// If either step fails, the container (when created) is terminated and the error
+// is wrapped with the service name and the tail of the container logs.
+func Start[C testcontainers.Container](
+	ctx context.Context,
+	settings *Settings,
+	start func(ctx context.Context) (C, error),
+	setup func(ctx context.Context, container C) error,
+) (C, error) {
+	var zero C
+
+	container, err := start(ctx)
+	if err != nil {
+		return zero, fail(ctx, settings, "start container", container, err)
+	}
+
+	if err := setup(ctx, container); err != nil {
+		return zero, fail(ctx, settings, "setup", container, err)
+	}
+
+	settings.Logger.DebugContext(ctx, "container started", slog.String("id", container.GetContainerID()))
+
+	return container, nil
+}
+
+// fail terminates the container if it was created and builds the resulting error.
+func fail(ctx context.Context, settings *Settings, step string, container testcontainers.Container, err error) error {
+	service := settings.Service
+	err = errors.Wrapf(err, "%s: %s", service, step)
+	settings.Logger.ErrorContext(ctx, "container failed to "+step, slog.Any("error", err))
+
+	if isNil(container) {
+		return err
+	}
+
+	if logs := LogTail(ctx, container); logs != "" {
+		err = &logsError{err: err, logs: logs}
+	}
+
+	if termErr := container.Terminate(context.WithoutCancel(ctx)); termErr != nil {
+		err = errors.Join(err, errors.Wrapf(termErr, "%s: terminate", service))
+	}
+
+	return err
+}
+
+// LogTail returns the last part of the container logs, or an empty string if
+// logs are not available.
+func LogTail(ctx context.Context, container testcontainers.Container) string {
+	logs, err := container.Logs(context.WithoutCancel(ctx))
+	if err != nil {
+		return ""
+	}
+	defer logs.Close()
+
+	data, err := io.ReadAll(logs)
+	if err != nil || len(data) == 0 {
+		return ""
+	}
+
+	if len(data) > logTailSize {
+		return "... (truncated)\n" + string(data[len(data)-logTailSize:])
+	}
+
+	return string(data)
+}
+
+// isNil reports whether the container is nil, including typed nil pointers
+// returned by testcontainers modules.
+func isNil(container testcontainers.Container) bool {
+	if container == nil {
+		return true
+	}
+
+	v := reflect.ValueOf(container)
+
+	return v.Kind() == reflect.Pointer && v.IsNil()
+}
@@ -0,0 +1,164 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	testcontainers "github.com/testcontainers/testcontainers-go"
+)
+
+type fakeContainer struct {
+	testcontainers.Container
+
+	logs       string
+	terminated bool
+}
+
+func (f *fakeContainer) Logs(context.Context) (io.ReadCloser, error) {
+	return io.NopCloser(strings.NewReader(f.logs)), nil
+}
+
+func (f *fakeContainer) Terminate(context.Context, ...testcontainers.TerminateOption) error {
+	f.terminated = true
+	return nil
+}
+
+func TestCustomizeReturnsError(t *testing.T) {
+	failing := testcontainers.CustomizeRequestOption(func(*testcontainers.GenericContainerRequest) error {
+		return errors.New("bad option")
+	})
+
+	var req testcontainers.GenericContainerRequest
+
+	_, err := Customize("redis", &req, testcontainers.WithImage("redis:7"), failing)
+	if err == nil || !strings.HasPrefix(err.Error(), "redis: customize request") {
+		t.Fatalf("Customize() error = %v, want wrapped with service name", err)
+	}
+
+	if req.Image != "redis:7" {
+		t.Errorf("options before the failing one must be applied")
+	}
+}
+
+func TestStartTerminatesOnSetupFailure(t *testing.T) {
+	container := &fakeContainer{logs: "fatal: something went wrong"}
+
+	_, err := Start(context.Background(), &Settings{Service: "postgres", Logger: Logger()},
+		func(context.Context) (*fakeContainer, error) {
+			return container, nil
+		},
+		func(context.Context, *fakeContainer) error {
+			return errors.New("no mapped port")
+		},
+	)
+	if err == nil {
+		t.Fatalf("Start() expected error")
+	}
+
+	if !container.terminated {
+		t.Errorf("container must be terminated on setup failure")
+	}
+
+	msg := err.Error()
+	if !strings.HasPrefix(msg, "postgres: setup") || !strings.Contains(msg, "something went wrong") {
+		t.Errorf("Start() error = %q, want service name and container logs", msg)
+	}
+}
+
+func TestStartWithNilContainer(t *testing.T) {
+	_, err := Start(context.Background(), &Settings{Service: "kafka", Logger: Logger()},
+		func(context.Context) (*fakeContainer, error) {
+			return nil, errors.New("pull failed")
+		},
+		func(context.Context, *fakeContainer) error {
+			t.Fatalf("setup must not be called")
+			return nil
+		},
+	)
+	if err == nil || !strings.HasPrefix(err.Error(), "kafka: start container") {
+		t.Errorf("Start() error = %v, want wrapped with service name", err)
+	}
+}
+
+func TestCustomizeCollectsRunOptions(t *testing.T) {
+	var records []string
+
+	l := slog.New(slog.NewTextHandler(writerFunc(func(p []byte) {
+		records = append(records, string(p))
+	}), &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	var req testcontainers.GenericContainerRequest
+
+	settings, err := Customize("redis", &req, WithLogger(l))
+	if err != nil {
+		t.Fatalf("Customize() error = %v", err)
+	}
+
+	settings.Logger.Debug("hello")
+
+	if len(records) != 1 || !strings.Contains(records[0], "service=redis") {
+		t.Errorf("records = %q, want one record with service attribute", records)
+	}
+}
+
+func TestCustomizeWithNilLogger(t *testing.T) {
+	var req testcontainers.GenericContainerRequest
+
+	settings, err := Customize("redis", &req, WithLogger(nil))
+	if err != nil {
+		t.Fatalf("Customize() error = %v", err)
+	}
+
+	if settings.Logger == nil {
+		t.Fatalf("Logger is nil, want the default logger")
+	}
+
+	settings.Logger.Debug("hello")
+}
+
+func TestCustomizeReuse(t *testing.T) {
+	customize := func(image string) testcontainers.GenericContainerRequest {
+		var req testcontainers.GenericContainerRequest
+
+		if _, err := Customize("redis", &req, WithReuse(), testcontainers.WithImage(image)); err != nil {
+			t.Fatalf("Customize() error = %v", err)
+		}
+
+		return req
+	}
+
+	first := customize("redis:7")
+	if !first.Reuse || !strings.HasPrefix(first.Name, "goat-redis-") {
+		t.Fatalf("request = reuse %v, name %q, want reuse by generated name", first.Reuse, first.Name)
+	}
+
+	if again := customize("redis:7"); again.Name != first.Name {
+		t.Errorf("reuse name is not deterministic: %q != %q", again.Name, first.Name)
+	}
+
+	if other := customize("redis:6"); other.Name == first.Name {
+		t.Errorf("reuse name does not depend on the image")
+	}
+
+	var req testcontainers.GenericContainerRequest
+	for _, opt := range ModuleOptions(&first) {
+		if err := opt.Customize(&req); err != nil {
+			t.Fatalf("ModuleOptions() customizer error = %v", err)
+		}
+	}
+
+	if !req.Reuse || req.Name != first.Name {
+		t.Errorf("ModuleOptions() must carry the reuse name")
+	}
+}
+
+type writerFunc func(p []byte)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	f(p)
+	return len(p), nil
+}
@@ -0,0 +1,104 @@
+package common
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	errors "github.com/go-faster/errors"
+	testcontainers "github.com/testcontainers/testcontainers-go"
+)
+
+const healthCheckTimeout = 5 * time.Second
+
+type (
+	// Service is implemented by the Env of every service package, so harness
+	// code can log, health-check and tear down services without knowing their types.
+	Service interface {
+		// ServiceName returns the instance name: the network alias if any, the kind otherwise.
+		ServiceName() string
+		// Kind returns the service type, e.g. "postgres" or "kafka".
+		Kind() string
+		// Endpoints returns the addresses of the service keyed by purpose.
+		// Addresses reachable only inside the network have the ".internal" key suffix.
+		Endpoints() map[string]string
+		// HealthCheck returns an error if the service does not respond.
+		HealthCheck(ctx context.Context) error
+		// ConnectionEnv returns environment variables for a client of the service,
+		// the conventional mapping of EnvVars without prefix.
+		ConnectionEnv() map[string]string
+		// EnvVars returns the connection variables with every name prefixed.
+		EnvVars(prefix string) map[string]string
+		// Terminate stops and removes the container.
+		Terminate(ctx context.Context, opts ...testcontainers.TerminateOption) error
+	}
+)
+
+// ServiceName returns the network alias of the request, falling back to kind.
+func ServiceName(req *testcontainers.GenericContainerRequest, kind string) string {
+	if alias := NetworkAlias(req); alias != "" {
+		return alias
+	}
+
+	return kind
+}
+
+// AddInternalEndpoint sets endpoints[key+".internal"] when address is not empty.
+func AddInternalEndpoint(endpoints map[string]string, key, address string) {
+	if address != "" {
+		endpoints[key+".internal"] = address
+	}
+}
+
+// CheckTCP verifies that a TCP connection to address can be established.
+func CheckTCP(ctx context.Context, address string) error {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	var d net.Dialer
+
+	conn, err := d.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return errors.Wrapf(err, "dial %s", address)
+	}
+
+	return conn.Close()
+}
+
+// CheckHTTP verifies that a GET request to url returns a status below 400.
+func CheckHTTP(ctx context.Context, url string) error {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "get %s", url)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return errors.Errorf("get %s: unexpected status %d", url, resp.StatusCode)
+	}
+
+	return nil
+}
+
+// CheckRunning verifies that the container is in the running state.
+func CheckRunning(ctx context.Context, container testcontainers.Container) error {
+	state, err := container.State(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !state.Running {
+		return errors.Errorf("container is %s", state.Status)
+	}
+
+	return nil
+}
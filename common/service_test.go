@@ -0,0 +1,48 @@
+package common
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCheckHTTP(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/broken" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	if err := CheckHTTP(context.Background(), srv.URL+"/health"); err != nil {
+		t.Errorf("CheckHTTP() healthy endpoint error = %v", err)
+	}
+
+	if err := CheckHTTP(context.Background(), srv.URL+"/broken"); err == nil {
+		t.Errorf("CheckHTTP() expected error for status 503")
+	}
+}
+
+func TestCheckTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+
+	addr := l.Addr().String()
+
+	if err := CheckTCP(context.Background(), addr); err != nil {
+		t.Errorf("CheckTCP() open port error = %v", err)
+	}
+
+	if err := l.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	if err := CheckTCP(context.Background(), addr); err == nil || !strings.Contains(err.Error(), addr) {
+		t.Errorf("CheckTCP() closed port error = %v, want error mentioning %s", err, addr)
+	}
+}
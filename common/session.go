@@ -0,0 +1,291 @@
+package common
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+	errors "github.com/go-faster/errors"
+)
+
+const (
+	// SessionLabel marks containers and networks that belong to one session,
+	// so they can be removed together, see RemoveSession.
+	SessionLabel = "goat.session"
+	// OwnerLabel holds host/pid of the process that created the resource,
+	// or DetachedOwner, see Reap.
+	OwnerLabel = "goat.owner"
+	// CreatedLabel holds the creation time in RFC 3339 format.
+	CreatedLabel = "goat.created"
+	// KindLabel holds the service kind of a container.
+	KindLabel = "goat.kind"
+
+	// DetachedOwner is the owner of resources meant to outlive their process:
+	// detached stacks, shared and reused containers. Reap removes them by TTL only.
+	DetachedOwner = "detached"
+
+	// SessionEnv sets the session of the process, e.g. to the CI job id,
+	// instead of a random one.
+	SessionEnv = "GOAT_SESSION"
+
+	// legacySingboxLabel marks MTU networks created before session labels.
+	legacySingboxLabel = "goat.singbox"
+)
+
+type (
+	// ReapOptions select the resources removed by Reap.
+	ReapOptions struct {
+		// TTL removes resources created longer ago. Zero disables the age check.
+		TTL time.Duration
+		// DeadSessions removes resources whose owner process on this host has exited.
+		DeadSessions bool
+		// DryRun only reports what would be removed.
+		DryRun bool
+	}
+
+	// ReapResult lists the removed, or with DryRun the matching, resources.
+	ReapResult struct {
+		Containers []string
+		Networks   []string
+	}
+)
+
+var (
+	sessionOnce sync.Once
+	session     string
+)
+
+// NewSessionID returns a random session identifier.
+func NewSessionID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b) //nolint:errcheck // crypto/rand.Read never fails
+
+	return hex.EncodeToString(b)
+}
+
+// Session returns the session of the process: the value of SessionEnv or a
+// random identifier generated on first use.
+func Session() string {
+	sessionOnce.Do(func() {
+		session = os.Getenv(SessionEnv)
+		if session == "" {
+			session = NewSessionID()
+		}
+	})
+
+	return session
+}
+
+// SessionLabels returns the session, owner and created labels that every
+// container and network created by the module carries.
+func SessionLabels() map[string]string {
+	return map[string]string{
+		SessionLabel: Session(),
+		OwnerLabel:   owner(),
+		CreatedLabel: time.Now().UTC().Format(time.RFC3339),
+	}
+}
+
+// RemoveSession force-removes all containers, with their volumes, and then all
+// networks labeled with the session.
+func RemoveSession(ctx context.Context, session string) error {
+	if session == "" {
+		return errors.New("session is empty")
+	}
+
+	cli, err := newDockerClient()
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	filter := filters.NewArgs(filters.Arg("label", SessionLabel+"="+session))
+
+	containers, err := cli.ContainerList(ctx, container.ListOptions{All: true, Filters: filter})
+	if err != nil {
+		return errors.Wrap(err, "list containers")
+	}
+
+	networks, err := cli.NetworkList(ctx, network.ListOptions{Filters: filter})
+	if err != nil {
+		return errors.Wrap(err, "list networks")
+	}
+
+	_, err = removeResources(ctx, cli, containers, networks)
+
+	return err
+}
+
+// Reap removes containers and networks of the module left behind by killed
+// processes or runs without the Ryuk reaper: those older than TTL and, with
+// DeadSessions, those whose owner process on this host has exited.
+// Resources of other hosts and DetachedOwner resources are removed by TTL only.
+// DeadSessions is an error on platforms other than unix and Windows, where
+// processes cannot be looked up.
+func Reap(ctx context.Context, opts ReapOptions) (*ReapResult, error) {
+	if opts.TTL <= 0 && !opts.DeadSessions {
+		return nil, errors.New("reap: neither TTL nor dead sessions selected")
+	}
+
+	if opts.DeadSessions && !processLookup {
+		return nil, errors.Errorf("reap: dead sessions cannot be detected on %s, use TTL", runtime.GOOS)
+	}
+
+	cli, err := newDockerClient()
+	if err != nil {
+		return nil, err
+	}
+	defer cli.Close()
+
+	now := time.Now()
+	expired := func(labels map[string]string, created time.Time) bool {
+		if t, err := time.Parse(time.RFC3339, labels[CreatedLabel]); err == nil {
+			created = t
+		}
+
+		if opts.TTL > 0 && now.Sub(created) > opts.TTL {
+			return true
+		}
+
+		return opts.DeadSessions && ownerExited(labels[OwnerLabel])
+	}
+
+	list, err := cli.ContainerList(ctx, container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", SessionLabel)),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "list containers")
+	}
+
+	var containers []container.Summary
+
+	for _, c := range list {
+		if expired(c.Labels, time.Unix(c.Created, 0)) {
+			containers = append(containers, c)
+		}
+	}
+
+	var networks []network.Summary
+
+	for _, label := range []string{SessionLabel, legacySingboxLabel} {
+		list, err := cli.NetworkList(ctx, network.ListOptions{Filters: filters.NewArgs(filters.Arg("label", label))})
+		if err != nil {
+			return nil, errors.Wrap(err, "list networks")
+		}
+
+		for _, n := range list {
+			if expired(n.Labels, n.Created) && !containsNetwork(networks, n.ID) {
+				networks = append(networks, n)
+			}
+		}
+	}
+
+	if opts.DryRun {
+		return newReapResult(containers, networks), nil
+	}
+
+	return removeResources(ctx, cli, containers, networks)
+}
+
+// removeResources removes the containers and then the networks, continuing on errors.
+func removeResources(
+	ctx context.Context,
+	cli *client.Client,
+	containers []container.Summary,
+	networks []network.Summary,
+) (*ReapResult, error) {
+	var (
+		removed ReapResult
+		errs    []error
+	)
+
+	for _, c := range containers {
+		err := cli.ContainerRemove(ctx, c.ID, container.RemoveOptions{Force: true, RemoveVolumes: true})
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "remove container %s", c.ID))
+			continue
+		}
+
+		removed.Containers = append(removed.Containers, containerName(c))
+	}
+
+	for _, n := range networks {
+		if err := cli.NetworkRemove(ctx, n.ID); err != nil {
+			errs = append(errs, errors.Wrapf(err, "remove network %s", n.Name))
+			continue
+		}
+
+		removed.Networks = append(removed.Networks, n.Name)
+	}
+
+	return &removed, errors.Join(errs...)
+}
+
+func newReapResult(containers []container.Summary, networks []network.Summary) *ReapResult {
+	var result ReapResult
+
+	for _, c := range containers {
+		result.Containers = append(result.Containers, containerName(c))
+	}
+
+	for _, n := range networks {
+		result.Networks = append(result.Networks, n.Name)
+	}
+
+	return &result
+}
+
+func containerName(c container.Summary) string {
+	if len(c.Names) > 0 {
+		return strings.TrimPrefix(c.Names[0], "/")
+	}
+
+	return c.ID
+}
+
+func containsNetwork(networks []network.Summary, id string) bool {
+	for _, n := range networks {
+		if n.ID == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// owner returns host/pid of the current process.
+func owner() string {
+	host, _ := os.Hostname() //nolint:errcheck // an empty host never matches in ownerExited
+
+	return host + "/" + strconv.Itoa(os.Getpid())
+}
+
+// ownerExited reports whether the owner is a process of this host that has exited.
+func ownerExited(owner string) bool {
+	host, pid, ok := strings.Cut(owner, "/")
+	if !ok {
+		return false
+	}
+
+	if current, err := os.Hostname(); err != nil || host == "" || host != current {
+		return false
+	}
+
+	n, err := strconv.Atoi(pid)
+	if err != nil {
+		return false
+	}
+
+	return !processAlive(n)
+}
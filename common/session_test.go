@@ -0,0 +1,60 @@
+package common
+
+import (
+	"os"
+	"strconv"
+	"testing"
+
+	testcontainers "github.com/testcontainers/testcontainers-go"
+)
+
+func TestOwnerExited(t *testing.T) {
+	host, err := os.Hostname()
+	if err != nil {
+		t.Skip("no host name")
+	}
+
+	tests := []struct {
+		owner    string
+		expected bool
+	}{
+		{owner: owner(), expected: false},
+		{owner: host + "/" + strconv.Itoa(1<<30), expected: true},
+		{owner: "other-host/" + strconv.Itoa(1<<30), expected: false},
+		{owner: DetachedOwner, expected: false},
+		{owner: "", expected: false},
+	}
+
+	for _, tt := range tests {
+		if got := ownerExited(tt.owner); got != tt.expected {
+			t.Errorf("ownerExited(%q) = %v, want %v", tt.owner, got, tt.expected)
+		}
+	}
+}
+
+func TestCustomizeAddsSessionLabels(t *testing.T) {
+	var req testcontainers.GenericContainerRequest
+
+	_, err := Customize("redis", &req, testcontainers.WithLabels(map[string]string{SessionLabel: "stack"}))
+	if err != nil {
+		t.Fatalf("Customize() error = %v", err)
+	}
+
+	if req.Labels[SessionLabel] != "stack" {
+		t.Errorf("session label = %q, explicit label must win", req.Labels[SessionLabel])
+	}
+
+	if req.Labels[KindLabel] != "redis" || req.Labels[OwnerLabel] != owner() || req.Labels[CreatedLabel] == "" {
+		t.Errorf("labels = %v, want kind, owner and created", req.Labels)
+	}
+
+	req = testcontainers.GenericContainerRequest{}
+
+	if _, err := Customize("redis", &req, WithReuse()); err != nil {
+		t.Fatalf("Customize() error = %v", err)
+	}
+
+	if req.Labels[OwnerLabel] != DetachedOwner || req.Labels[SessionLabel] != Session() {
+		t.Errorf("labels = %v, want detached owner of the process session", req.Labels)
+	}
+}
@@ -0,0 +1,332 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sync"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	errors "github.com/go-faster/errors"
+	testcontainers "github.com/testcontainers/testcontainers-go"
+)
+
+// SharedDirEnv overrides the directory of the state files of shared
+// services, by default goat-services in the temporary directory.
+const SharedDirEnv = "GOAT_SHARED_DIR"
+
+type (
+	// sharedState is stored next to the lock file of a shared service.
+	sharedState struct {
+		// Users are the pids of the processes using the service.
+		Users    []int     `json:"users"`
+		Document *Document `json:"document,omitempty"`
+	}
+)
+
+// Share starts a service once for all processes of a test run, e.g. all
+// package binaries of `go test ./...`, and returns it with a release
+// function to call when the process is done with it.
+//
+// The first process runs the service and records it in a state file keyed by
+// the kind and RequestHash of opts; later processes with the same options
+// load the record and attach to the running container. The state file is
+// lock protected and counts the users by pid, dead processes are dropped,
+// and the last release terminates the container.
+//
+// A shared container outlives the process that started it, so the Ryuk
+// reaper must be disabled with TESTCONTAINERS_RYUK_DISABLED=true.
+// The kind is taken from the zero value of T, so T must be a pointer Env.
+//
+//	pg, release, err := common.Share(ctx, psql.Run, psql.Load, postgres.WithDatabase("app"))
+func Share[T Service](
+	ctx context.Context,
+	run func(ctx context.Context, opts ...testcontainers.ContainerCustomizer) (T, error),
+	load func(doc *Document, name string) (T, error),
+	opts ...testcontainers.ContainerCustomizer,
+) (T, func(ctx context.Context) error, error) {
+	var zero T
+
+	if !testcontainers.ReadConfig().RyukDisabled {
+		return zero, nil, errors.New("shared services outlive the starting process, set TESTCONTAINERS_RYUK_DISABLED=true")
+	}
+
+	kind := zero.Kind()
+
+	key, err := RequestHash(kind, opts...)
+	if err != nil {
+		return zero, nil, errors.Wrapf(err, "%s: hash options", kind)
+	}
+
+	dir := sharedDir()
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return zero, nil, err
+	}
+
+	base := filepath.Join(dir, kind+"-"+key)
+	logger := Logger().With(slog.String("service", kind), slog.String("key", key))
+
+	unlock, err := lockFile(ctx, base+".lock")
+	if err != nil {
+		return zero, nil, err
+	}
+	defer unlock() //nolint:errcheck // the lock is released with the file descriptor anyway
+
+	state, err := readSharedState(base + ".json")
+	if err != nil {
+		return zero, nil, err
+	}
+
+	var env T
+
+	if state.Document != nil {
+		env, err = attachShared(ctx, state.Document, load)
+		if err != nil {
+			logger.WarnContext(ctx, "shared service is gone, starting a new one", slog.Any("error", err))
+
+			// the container may still run, e.g. when the health check failed
+			if err := removeRecorded(ctx, state.Document, true); err != nil {
+				logger.WarnContext(ctx, "stale shared service is left to Reap", slog.Any("error", err))
+			}
+
+			state = &sharedState{}
+		} else {
+			logger.InfoContext(ctx, "attached to shared service", slog.Any("users", state.Users))
+		}
+	}
+
+	if state.Document == nil {
+		// the container outlives this process, keep Reap from removing it as orphaned
+		detached := testcontainers.WithLabels(map[string]string{OwnerLabel: DetachedOwner})
+
+		// cloned, as appending could write into the array of the caller
+		env, err = run(ctx, append(slices.Clone(opts), detached)...)
+		if err != nil {
+			return zero, nil, err
+		}
+
+		record, err := newServiceRecord(env)
+		if err != nil {
+			return zero, nil, errors.Join(err, env.Terminate(context.WithoutCancel(ctx)))
+		}
+
+		state.Document = &Document{Services: []ServiceRecord{record}}
+		logger.InfoContext(ctx, "started shared service")
+	}
+
+	state.Users = append(state.Users, os.Getpid())
+
+	if err := writeSharedState(base+".json", state); err != nil {
+		return zero, nil, err
+	}
+
+	var once sync.Once
+
+	release := func(ctx context.Context) error {
+		err := errors.New("already released")
+		once.Do(func() {
+			err = releaseShared(ctx, base, env)
+		})
+
+		return err
+	}
+
+	return env, release, nil
+}
+
+// AttachContainer returns a handle to a running container by id, e.g. one
+// started by another process.
+func AttachContainer(ctx context.Context, id string) (testcontainers.Container, error) {
+	provider, err := testcontainers.NewDockerProvider()
+	if err != nil {
+		return nil, err
+	}
+
+	containers, err := provider.Client().ContainerList(ctx, container.ListOptions{
+		Filters: filters.NewArgs(filters.Arg("id", id)),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "list containers")
+	}
+
+	if len(containers) == 0 {
+		return nil, errors.Errorf("container %s is not running", id)
+	}
+
+	return provider.ContainerFromType(ctx, containers[0])
+}
+
+// removeContainer removes a container by id. A missing container is not an error.
+func removeContainer(ctx context.Context, id string) error {
+	if id == "" {
+		return nil
+	}
+
+	cli, err := newDockerClient()
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	err = cli.ContainerRemove(ctx, id, container.RemoveOptions{Force: true, RemoveVolumes: true})
+	if err != nil && !client.IsErrNotFound(err) {
+		return errors.Wrapf(err, "remove container %s", id)
+	}
+
+	return nil
+}
+
+// removeNetwork removes a network by id or name. A missing network is not an error.
+func removeNetwork(ctx context.Context, id string) error {
+	cli, err := newDockerClient()
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	if err := cli.NetworkRemove(ctx, id); err != nil && !client.IsErrNotFound(err) {
+		return errors.Wrapf(err, "remove network %s", id)
+	}
+
+	return nil
+}
+
+func attachShared[T Service](ctx context.Context, doc *Document, load func(*Document, string) (T, error)) (T, error) {
+	var zero T
+
+	if len(doc.Services) != 1 {
+		return zero, errors.Errorf("state has %d services, expected 1", len(doc.Services))
+	}
+
+	record := doc.Services[0]
+
+	env, err := load(doc, record.Name)
+	if err != nil {
+		return zero, err
+	}
+
+	c, err := AttachContainer(ctx, record.ContainerID)
+	if err != nil {
+		return zero, err
+	}
+
+	v := reflect.ValueOf(env)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return zero, errors.Errorf("%T is not a pointer to an Env", env)
+	}
+
+	if field := v.Elem().FieldByName("Container"); field.CanSet() {
+		field.Set(reflect.ValueOf(c))
+	}
+
+	if err := env.HealthCheck(ctx); err != nil {
+		return zero, err
+	}
+
+	return env, nil
+}
+
+func releaseShared(ctx context.Context, base string, env Service) error {
+	unlock, err := lockFile(ctx, base+".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock() //nolint:errcheck // the lock is released with the file descriptor anyway
+
+	state, err := readSharedState(base + ".json")
+	if err != nil {
+		return err
+	}
+
+	pid := os.Getpid()
+	state.Users = slices.DeleteFunc(state.Users, func(user int) bool { return user == pid })
+
+	if len(state.Users) > 0 {
+		return writeSharedState(base+".json", state)
+	}
+
+	Logger().InfoContext(ctx, "terminating shared service", slog.String("service", env.Kind()))
+
+	// an attached Env does not know the sidecars of the process that started it
+	err = env.Terminate(ctx)
+	if state.Document != nil {
+		err = errors.Join(err, removeRecorded(ctx, state.Document, false))
+	}
+
+	return errors.Join(err, os.Remove(base+".json"))
+}
+
+// removeRecorded removes the sidecars and networks of the services of doc
+// and, with services, their containers. Missing ones are skipped.
+func removeRecorded(ctx context.Context, doc *Document, services bool) error {
+	var errs []error
+
+	for _, record := range doc.Services {
+		ids := record.Sidecars
+		if services {
+			ids = append(slices.Clone(ids), record.ContainerID)
+		}
+
+		for _, id := range ids {
+			errs = append(errs, removeContainer(ctx, id))
+		}
+	}
+
+	// networks go last, they cannot be removed while containers use them
+	for _, record := range doc.Services {
+		for _, id := range record.Networks {
+			errs = append(errs, removeNetwork(ctx, id))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// readSharedState reads the state file, dropping users that are no longer alive.
+func readSharedState(path string) (*sharedState, error) {
+	state := &sharedState{}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, errors.Wrapf(err, "parse %s", path)
+	}
+
+	state.Users = slices.DeleteFunc(state.Users, func(pid int) bool { return !processAlive(pid) })
+
+	return state, nil
+}
+
+// writeSharedState replaces the state file atomically.
+func writeSharedState(path string, state *sharedState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+func sharedDir() string {
+	if dir := os.Getenv(SharedDirEnv); dir != "" {
+		return dir
+	}
+
+	return filepath.Join(os.TempDir(), "goat-services")
+}
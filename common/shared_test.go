@@ -0,0 +1,97 @@
+package common
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	testcontainers "github.com/testcontainers/testcontainers-go"
+)
+
+func TestRequestHash(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(config, []byte(`{"a":1}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := func(value string) []testcontainers.ContainerCustomizer {
+		return []testcontainers.ContainerCustomizer{
+			testcontainers.WithEnv(map[string]string{"KEY": value}),
+			testcontainers.WithFiles(testcontainers.ContainerFile{HostFilePath: config, ContainerFilePath: "/config.json"}),
+		}
+	}
+
+	first, err := RequestHash("postgres", opts("a")...)
+	if err != nil {
+		t.Fatalf("RequestHash() error = %v", err)
+	}
+
+	if again, _ := RequestHash("postgres", opts("a")...); again != first {
+		t.Errorf("RequestHash() is not stable: %s != %s", again, first)
+	}
+
+	if other, _ := RequestHash("postgres", opts("b")...); other == first {
+		t.Errorf("RequestHash() ignores env")
+	}
+
+	if other, _ := RequestHash("redis", opts("a")...); other == first {
+		t.Errorf("RequestHash() ignores kind")
+	}
+
+	if err := os.WriteFile(config, []byte(`{"a":2}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if other, _ := RequestHash("postgres", opts("a")...); other == first {
+		t.Errorf("RequestHash() ignores file content")
+	}
+}
+
+func TestSharedStateDropsDeadUsers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	// pids are far below 1<<30 on every supported platform
+	state := &sharedState{Users: []int{os.Getpid(), 1 << 30}}
+	if err := writeSharedState(path, state); err != nil {
+		t.Fatalf("writeSharedState() error = %v", err)
+	}
+
+	got, err := readSharedState(path)
+	if err != nil {
+		t.Fatalf("readSharedState() error = %v", err)
+	}
+
+	if !slices.Equal(got.Users, []int{os.Getpid()}) {
+		t.Errorf("users = %v, want only the current process", got.Users)
+	}
+}
+
+func TestLockFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.lock")
+
+	unlock, err := lockFile(context.Background(), path)
+	if err != nil {
+		t.Fatalf("lockFile() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	if _, err := lockFile(ctx, path); err == nil {
+		t.Fatalf("lockFile() acquired a held lock")
+	}
+
+	if err := unlock(); err != nil {
+		t.Fatalf("unlock() error = %v", err)
+	}
+
+	unlock, err = lockFile(context.Background(), path)
+	if err != nil {
+		t.Fatalf("lockFile() after unlock error = %v", err)
+	}
+
+	_ = unlock()
+}
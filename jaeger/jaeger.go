@@ -18,16 +18,84 @@ type (
 		HTTPCollectorAddress string
 		GRPCCollectorAddress string
 		Address              string // UI
+
+		// Internal* fields are set when the container has a network alias,
+		// see common.WithNetworkAlias.
+		InternalHTTPCollectorAddress string
+		InternalGRPCCollectorAddress string
+		InternalAddress              string // UI
+
+		name string
 	}
 )
 
-var defaultImage = common.DockerProxy("jaegertracing/all-in-one:1.51")
+const kind = "jaeger"
+
+var _ common.Service = (*Env)(nil)
+
+// ServiceName implements common.Service.
+func (e *Env) ServiceName() string {
+	return e.name
+}
+
+// Kind implements common.Service.
+func (e *Env) Kind() string {
+	return kind
+}
+
+// Endpoints implements common.Service.
+func (e *Env) Endpoints() map[string]string {
+	endpoints := map[string]string{
+		"ui":             e.Address,
+		"grpc-collector": e.GRPCCollectorAddress,
+		"http-collector": e.HTTPCollectorAddress,
+	}
+	common.AddInternalEndpoint(endpoints, "ui", e.InternalAddress)
+	common.AddInternalEndpoint(endpoints, "grpc-collector", e.InternalGRPCCollectorAddress)
+	common.AddInternalEndpoint(endpoints, "http-collector", e.InternalHTTPCollectorAddress)
+
+	return endpoints
+}
+
+// HealthCheck implements common.Service.
+func (e *Env) HealthCheck(ctx context.Context) error {
+	return common.CheckHTTP(ctx, e.Address)
+}
+
+// ConnectionEnv implements common.Service.
+func (e *Env) ConnectionEnv() map[string]string {
+	return e.EnvVars("")
+}
+
+// EnvVars implements common.Service.
+func (e *Env) EnvVars(prefix string) map[string]string {
+	return common.PrefixEnv(prefix, map[string]string{
+		"OTEL_EXPORTER_OTLP_ENDPOINT": "http://" + e.GRPCCollectorAddress,
+		"JAEGER_UI_URL":               e.Address,
+	})
+}
+
+// Load returns the Env of the named service from a document written by
+// common.WriteJSON. The Env has no container attached, so it must not be terminated.
+func Load(doc *common.Document, name string) (*Env, error) {
+	env := &Env{name: name}
+	if err := doc.Decode(name, env); err != nil {
+		return nil, err
+	}
+
+	return env, nil
+}
+
+func Run(ctx context.Context, opts ...testcontainers.ContainerCustomizer) (*Env, error) {
+	image, err := common.ResolveImage(kind)
+	if err != nil {
+		return nil, err
+	}
 
-func Run(ctx context.Context, opts ...testcontainers.ContainerCustomizer) (env *Env, err error) {
 	req := testcontainers.GenericContainerRequest{
 		Started: true,
 		ContainerRequest: testcontainers.ContainerRequest{
-			Image:             defaultImage,
+			Image:             image,
 			ImageSubstitutors: common.ImageSubstitutors(),
 			ExposedPorts: []string{
 				"14250/tcp",
@@ -43,49 +111,58 @@ func Run(ctx context.Context, opts ...testcontainers.ContainerCustomizer) (env *
 		},
 	}
 
-	for _, e := range opts {
-		_ = e.Customize(&req) //nolint:errcheck // options pattern, errors handled during container creation
-	}
-
-	container, err := testcontainers.GenericContainer(ctx, req)
+	settings, err := common.Customize(kind, &req, opts...)
 	if err != nil {
 		return nil, err
 	}
 
-	defer func() {
-		if err != nil {
-			err = errors.Join(err, container.Terminate(ctx))
-		}
-	}()
-
-	host, err := container.Host(ctx)
-	if err != nil {
-		return nil, errors.Errorf("Failed to get host: %v", err)
+	env := &Env{
+		name: common.ServiceName(&req, kind),
 	}
 
-	uiPort, err := container.MappedPort(ctx, "16686")
-	if err != nil {
-		return nil, errors.Errorf("Failed to get UI port: %v", err)
-	}
+	container, err := common.Start(ctx, settings,
+		func(ctx context.Context) (testcontainers.Container, error) {
+			return testcontainers.GenericContainer(ctx, req)
+		},
+		func(ctx context.Context, container testcontainers.Container) error {
+			host, err := container.Host(ctx)
+			if err != nil {
+				return errors.Wrap(err, "get host")
+			}
 
-	grpcCollectorPort, err := container.MappedPort(ctx, "4317")
-	if err != nil {
-		return nil, errors.Errorf("Failed to get gRPC collector port: %v", err)
-	}
+			uiPort, err := container.MappedPort(ctx, "16686")
+			if err != nil {
+				return errors.Wrap(err, "get UI port")
+			}
+
+			grpcCollectorPort, err := container.MappedPort(ctx, "4317")
+			if err != nil {
+				return errors.Wrap(err, "get gRPC collector port")
+			}
+
+			httpCollectorPort, err := container.MappedPort(ctx, "4318")
+			if err != nil {
+				return errors.Wrap(err, "get HTTP collector port")
+			}
 
-	httpCollectorPort, err := container.MappedPort(ctx, "4318")
+			env.Address = fmt.Sprintf("http://%s:%s", host, uiPort.Port())
+			env.GRPCCollectorAddress = net.JoinHostPort(host, grpcCollectorPort.Port())
+			env.HTTPCollectorAddress = net.JoinHostPort(host, httpCollectorPort.Port())
+
+			return nil
+		},
+	)
 	if err != nil {
-		return nil, errors.Errorf("Failed to get HTTP collector port: %v", err)
+		return nil, err
 	}
 
-	jaegerURL := fmt.Sprintf("http://%s:%s", host, uiPort.Port())
-	urlCollectorGRPC := net.JoinHostPort(host, grpcCollectorPort.Port())
-	urlCollectorHTTP := net.JoinHostPort(host, httpCollectorPort.Port())
+	env.Container = container
+
+	if alias := common.NetworkAlias(&req); alias != "" {
+		env.InternalAddress = "http://" + net.JoinHostPort(alias, "16686")
+		env.InternalGRPCCollectorAddress = net.JoinHostPort(alias, "4317")
+		env.InternalHTTPCollectorAddress = net.JoinHostPort(alias, "4318")
+	}
 
-	return &Env{
-		Container:            container,
-		Address:              jaegerURL,
-		GRPCCollectorAddress: urlCollectorGRPC,
-		HTTPCollectorAddress: urlCollectorHTTP,
-	}, nil
+	return env, nil
 }
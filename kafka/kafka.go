@@ -4,6 +4,7 @@ import (
 	"context"
 	"strings"
 
+	"github.com/docker/docker/api/types/container"
 	testcontainers "github.com/testcontainers/testcontainers-go"
 	kafka "github.com/testcontainers/testcontainers-go/modules/kafka"
 
@@ -16,13 +17,125 @@ type (
 		Brokers     string
 		BrokersHost string
 		BrokersPort string
+
+		// InternalBrokers is alias:9092 when the container has a network alias.
+		// It points to the BROKER listener, which advertises the alias as host name.
+		InternalBrokers string
+
+		name string
 	}
 )
 
+const (
+	kind = "kafka"
+
+	// bootstrapServer is the BROKER listener as seen from inside the container.
+	bootstrapServer = "localhost:9092"
+)
+
 var (
-	defaultImage = common.DockerProxy("confluentinc/confluent-local:7.6.0")
+	_ common.Service  = (*Env)(nil)
+	_ common.Resetter = (*Env)(nil)
 )
 
+// ServiceName implements common.Service.
+func (e *Env) ServiceName() string {
+	return e.name
+}
+
+// Kind implements common.Service.
+func (e *Env) Kind() string {
+	return kind
+}
+
+// Endpoints implements common.Service.
+func (e *Env) Endpoints() map[string]string {
+	endpoints := map[string]string{
+		"brokers": e.Brokers,
+	}
+	common.AddInternalEndpoint(endpoints, "brokers", e.InternalBrokers)
+
+	return endpoints
+}
+
+// HealthCheck implements common.Service.
+func (e *Env) HealthCheck(ctx context.Context) error {
+	for _, broker := range strings.Split(e.Brokers, ",") {
+		if err := common.CheckTCP(ctx, broker); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Reset implements common.Resetter. It deletes all consumer groups and all
+// topics except the internal ones.
+func (e *Env) Reset(ctx context.Context) error {
+	groups, err := e.list(ctx, "kafka-consumer-groups")
+	if err != nil {
+		return err
+	}
+
+	for _, group := range groups {
+		if _, err := common.Exec(ctx, e.Container,
+			"kafka-consumer-groups", "--bootstrap-server", bootstrapServer, "--delete", "--group", group); err != nil {
+			return err
+		}
+	}
+
+	topics, err := e.list(ctx, "kafka-topics")
+	if err != nil {
+		return err
+	}
+
+	for _, topic := range topics {
+		if strings.HasPrefix(topic, "_") {
+			continue
+		}
+
+		if _, err := common.Exec(ctx, e.Container,
+			"kafka-topics", "--bootstrap-server", bootstrapServer, "--delete", "--topic", topic); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// list runs a kafka command line tool with --list and returns the listed names.
+func (e *Env) list(ctx context.Context, tool string) ([]string, error) {
+	out, err := common.Exec(ctx, e.Container, tool, "--bootstrap-server", bootstrapServer, "--list")
+	if err != nil {
+		return nil, err
+	}
+
+	return strings.Fields(out), nil
+}
+
+// ConnectionEnv implements common.Service.
+func (e *Env) ConnectionEnv() map[string]string {
+	return e.EnvVars("")
+}
+
+// EnvVars implements common.Service.
+func (e *Env) EnvVars(prefix string) map[string]string {
+	return common.PrefixEnv(prefix, map[string]string{
+		"KAFKA_BROKERS": e.Brokers,
+	})
+}
+
+// Load returns the Env of the named service from a document written by
+// common.WriteJSON. The Env has no container attached, so it must not be terminated.
+func Load(doc *common.Document, name string) (*Env, error) {
+	env := &Env{name: name}
+	if err := doc.Decode(name, env); err != nil {
+		return nil, err
+	}
+
+	return env, nil
+}
+
 func Run(ctx context.Context, opts ...testcontainers.ContainerCustomizer) (*Env, error) {
 	req := testcontainers.GenericContainerRequest{
 		ContainerRequest: testcontainers.ContainerRequest{
@@ -30,32 +143,53 @@ func Run(ctx context.Context, opts ...testcontainers.ContainerCustomizer) (*Env,
 		},
 	}
 
-	for _, e := range opts {
-		_ = e.Customize(&req) //nolint:errcheck
+	settings, err := common.Customize(kind, &req, opts...)
+	if err != nil {
+		return nil, err
 	}
 
-	image := defaultImage
-	if req.Image != "" {
-		image = req.Image
+	image := req.Image
+	if image == "" {
+		if image, err = common.ResolveImage(kind); err != nil {
+			return nil, err
+		}
+	}
+
+	// The BROKER listener advertises the container host name, so make it
+	// equal to the alias to keep the advertised address resolvable in the network.
+	alias := common.NetworkAlias(&req)
+	if alias != "" {
+		opts = append(opts, testcontainers.WithConfigModifier(func(config *container.Config) {
+			config.Hostname = alias
+		}))
 	}
 
 	// Note: kafka.Run() from testcontainers-go/modules/kafka has its own
 	// internal waiting logic. We don't override WaitingFor to avoid conflicts
 	// with the multi-port confluent-local image (8082 REST proxy is slow to start).
 
-	container, err := kafka.Run(ctx, image, opts...)
-	if err != nil {
-		return nil, err
-	}
+	opts = append(common.ModuleOptions(&req), opts...)
 
-	brokers, err := container.Brokers(ctx)
+	var brokers []string
+
+	container, err := common.Start(ctx, settings,
+		func(ctx context.Context) (*kafka.KafkaContainer, error) {
+			return kafka.Run(ctx, image, opts...)
+		},
+		func(ctx context.Context, container *kafka.KafkaContainer) (err error) {
+			brokers, err = container.Brokers(ctx)
+			return err
+		},
+	)
 	if err != nil {
 		return nil, err
 	}
 
 	var env Env
 	env.Container = container
+	env.name = common.ServiceName(&req, kind)
 	env.Brokers = strings.Join(brokers, ",")
+	env.InternalBrokers = common.InternalAddress(alias, "9092")
 
 	if len(brokers) > 0 {
 		parts := strings.Split(brokers[0], ":")
@@ -22,11 +22,19 @@ type (
 		SecretAccessKey string
 		Region          string
 		Token           string
+
+		// InternalEndpointURL is alias:9000 when the container has a network alias.
+		InternalEndpointURL string
+
+		name string
 	}
 )
 
+const kind = "minio"
+
 var (
-	defaultImage = common.DockerProxy("minio/minio")
+	_ common.Service  = (*Env)(nil)
+	_ common.Resetter = (*Env)(nil)
 )
 
 func (env *Env) GetMinioClient() (*minio.Client, error) {
@@ -36,11 +44,88 @@ func (env *Env) GetMinioClient() (*minio.Client, error) {
 	})
 }
 
+// ServiceName implements common.Service.
+func (env *Env) ServiceName() string {
+	return env.name
+}
+
+// Kind implements common.Service.
+func (env *Env) Kind() string {
+	return kind
+}
+
+// Endpoints implements common.Service.
+func (env *Env) Endpoints() map[string]string {
+	endpoints := map[string]string{
+		"s3": env.EndpointURL,
+	}
+	common.AddInternalEndpoint(endpoints, "s3", env.InternalEndpointURL)
+
+	return endpoints
+}
+
+// HealthCheck implements common.Service.
+func (env *Env) HealthCheck(ctx context.Context) error {
+	return common.CheckHTTP(ctx, "http://"+env.EndpointURL+"/minio/health/live")
+}
+
+// Reset implements common.Resetter. It deletes all buckets with their objects.
+func (env *Env) Reset(ctx context.Context) error {
+	client, err := env.GetMinioClient()
+	if err != nil {
+		return err
+	}
+
+	buckets, err := client.ListBuckets(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, bucket := range buckets {
+		if err := client.RemoveBucketWithOptions(ctx, bucket.Name, minio.RemoveBucketOptions{ForceDelete: true}); err != nil {
+			return errors.Wrapf(err, "delete bucket %s", bucket.Name)
+		}
+	}
+
+	return nil
+}
+
+// ConnectionEnv implements common.Service.
+func (env *Env) ConnectionEnv() map[string]string {
+	return env.EnvVars("")
+}
+
+// EnvVars implements common.Service.
+func (env *Env) EnvVars(prefix string) map[string]string {
+	return common.PrefixEnv(prefix, map[string]string{
+		"AWS_ENDPOINT_URL":      "http://" + env.EndpointURL,
+		"AWS_ACCESS_KEY_ID":     env.AccessKeyID,
+		"AWS_SECRET_ACCESS_KEY": env.SecretAccessKey,
+		"AWS_REGION":            env.Region,
+	})
+}
+
+// Load returns the Env of the named service from a document written by
+// common.WriteJSON. The Env has no container attached, so it must not be terminated.
+func Load(doc *common.Document, name string) (*Env, error) {
+	env := &Env{name: name}
+	if err := doc.Decode(name, env); err != nil {
+		return nil, err
+	}
+
+	return env, nil
+}
+
 func Run(ctx context.Context, opts ...testcontainers.ContainerCustomizer) (*Env, error) {
+	image, err := common.ResolveImage(kind)
+	if err != nil {
+		return nil, err
+	}
+
 	req := testcontainers.GenericContainerRequest{
 		Started: true,
 		ContainerRequest: testcontainers.ContainerRequest{
-			Image:             defaultImage,
+			Image:             image,
 			ImageSubstitutors: common.ImageSubstitutors(),
 			Cmd:               []string{"server", "/data"},
 			ExposedPorts:      []string{"9000/tcp"},
@@ -52,32 +137,46 @@ func Run(ctx context.Context, opts ...testcontainers.ContainerCustomizer) (*Env,
 		},
 	}
 
-	for _, e := range opts {
-		_ = e.Customize(&req) //nolint:errcheck
-	}
-
-	container, err := testcontainers.GenericContainer(ctx, req)
+	settings, err := common.Customize(kind, &req, opts...)
 	if err != nil {
 		return nil, err
 	}
 
-	host, err := container.Host(ctx)
-	if err != nil {
-		_ = container.Terminate(ctx) //nolint:errcheck
-		return nil, errors.Errorf("Failed to get host: %v", err)
-	}
+	var endpointURL string
+
+	container, err := common.Start(ctx, settings,
+		func(ctx context.Context) (testcontainers.Container, error) {
+			return testcontainers.GenericContainer(ctx, req)
+		},
+		func(ctx context.Context, container testcontainers.Container) error {
+			host, err := container.Host(ctx)
+			if err != nil {
+				return errors.Wrap(err, "get host")
+			}
 
-	mappedPort, err := container.MappedPort(ctx, "9000")
+			mappedPort, err := container.MappedPort(ctx, "9000")
+			if err != nil {
+				return errors.Wrap(err, "get mapped port")
+			}
+
+			endpointURL = fmt.Sprintf("%s:%d", host, mappedPort.Int())
+
+			return nil
+		},
+	)
 	if err != nil {
-		_ = container.Terminate(ctx) //nolint:errcheck
-		return nil, errors.Errorf("Failed to get mapped port: %v", err)
+		return nil, err
 	}
 
 	return &Env{
 		Container:       container,
-		EndpointURL:     fmt.Sprintf("%s:%d", host, mappedPort.Int()),
+		EndpointURL:     endpointURL,
 		AccessKeyID:     "minioadmin",
 		SecretAccessKey: "minioadmin",
 		Region:          "us-east-1",
+
+		InternalEndpointURL: common.InternalAddress(common.NetworkAlias(&req), "9000"),
+
+		name: common.ServiceName(&req, kind),
 	}, nil
 }
@@ -0,0 +1,371 @@
+package psql
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	errors "github.com/go-faster/errors"
+	testcontainers "github.com/testcontainers/testcontainers-go"
+)
+
+const (
+	// capturePrefix starts every server log line, followed by pid, database
+	// and user: goat|<pid>|<database>|<user>|<message>.
+	capturePrefix     = "goat|"
+	captureLinePrefix = capturePrefix + "%p|%d|%u|"
+
+	// captureSentinel is part of the statements MarkStatements and
+	// CollectStatements run to find their position in the log.
+	captureSentinel = "goat-capture-"
+
+	logStatement = "LOG:  statement: "
+	logExecute   = "LOG:  execute "
+	logDuration  = "LOG:  duration: "
+	logParams    = "DETAIL:  parameters: "
+
+	// captureTimeout bounds the wait for the server log in Capture.
+	captureTimeout = 10 * time.Second
+)
+
+type (
+	// Statement is a statement the server logged.
+	Statement struct {
+		SQL string
+		// Params holds the parameters of a prepared statement, as logged:
+		// $1 = '42', $2 = 'x'.
+		Params   string
+		Database string
+		User     string
+		PID      int
+		// Duration is zero until the server logs it after completion.
+		Duration time.Duration
+	}
+
+	// Statements is a list of logged statements in log order.
+	Statements []Statement
+
+	// StatementMark is a position in the statement log, see MarkStatements.
+	StatementMark struct {
+		index int
+	}
+
+	// Capture collects the statements issued since it was created, failing
+	// the test on errors. See CaptureStatements.
+	Capture struct {
+		tb   testing.TB
+		env  *Env
+		mark StatementMark
+	}
+
+	// captureOption is returned by WithStatementCapture.
+	captureOption struct {
+		rec *recorder
+	}
+
+	// recorder is a log consumer parsing the statements of the server log.
+	recorder struct {
+		mu         sync.Mutex
+		statements Statements
+		partial    []byte
+		last       int         // statement continuation lines belong to, -1 if none
+		pending    map[int]int // pid to the statement waiting for its duration
+		changed    chan struct{}
+		seq        atomic.Uint64
+	}
+)
+
+// WithStatementCapture logs every statement with log_statement=all and
+// log_duration=on and records them from the container log, for
+// MarkStatements, CollectStatements and CaptureStatements.
+func WithStatementCapture() testcontainers.ContainerCustomizer {
+	return captureOption{rec: newRecorder()}
+}
+
+// Customize implements testcontainers.ContainerCustomizer.
+func (o captureOption) Customize(req *testcontainers.GenericContainerRequest) error {
+	err := WithSettings(map[string]string{
+		"log_statement":     "all",
+		"log_duration":      "on",
+		"log_line_prefix":   captureLinePrefix,
+		"logging_collector": "off",
+	})(req)
+	if err != nil {
+		return err
+	}
+
+	if req.LogConsumerCfg == nil {
+		req.LogConsumerCfg = &testcontainers.LogConsumerConfig{}
+	}
+
+	req.LogConsumerCfg.Consumers = append(req.LogConsumerCfg.Consumers, o.rec)
+
+	return nil
+}
+
+// MarkStatements returns the current end of the statement log. It runs a
+// marker statement and waits until the server logged it, so statements issued
+// before are not collected after the mark.
+func (e *Env) MarkStatements(ctx context.Context) (StatementMark, error) {
+	i, err := e.syncStatements(ctx)
+	if err != nil {
+		return StatementMark{}, err
+	}
+
+	return StatementMark{index: i + 1}, nil
+}
+
+// CollectStatements returns the statements logged since the mark, on every
+// database and connection of the server, without the marker statements.
+func (e *Env) CollectStatements(ctx context.Context, mark StatementMark) (Statements, error) {
+	end, err := e.syncStatements(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	e.rec.mu.Lock()
+	defer e.rec.mu.Unlock()
+
+	var statements Statements
+
+	for _, stmt := range e.rec.statements[min(mark.index, end):end] {
+		if !strings.Contains(stmt.SQL, captureSentinel) {
+			statements = append(statements, stmt)
+		}
+	}
+
+	return statements, nil
+}
+
+// CaptureStatements marks the statement log, the returned Capture collects
+// and asserts on the statements issued afterwards.
+func (e *Env) CaptureStatements(tb testing.TB) *Capture {
+	tb.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), captureTimeout)
+	defer cancel()
+
+	mark, err := e.MarkStatements(ctx)
+	if err != nil {
+		tb.Fatalf("psql: %v", err)
+	}
+
+	return &Capture{tb: tb, env: e, mark: mark}
+}
+
+// syncStatements runs a marker statement and returns its index in the log.
+func (e *Env) syncStatements(ctx context.Context) (int, error) {
+	if e.rec == nil {
+		return 0, errors.New("statement capture is not enabled, see WithStatementCapture")
+	}
+
+	db, err := e.SQL()
+	if err != nil {
+		return 0, err
+	}
+
+	marker := captureSentinel + strconv.FormatUint(e.rec.seq.Add(1), 10)
+	if _, err := db.ExecContext(ctx, "SELECT '"+marker+"'"); err != nil {
+		return 0, errors.Wrap(err, "run capture marker")
+	}
+
+	return e.rec.wait(ctx, marker)
+}
+
+// Statements returns the statements issued since the Capture was created.
+func (c *Capture) Statements() Statements {
+	c.tb.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), captureTimeout)
+	defer cancel()
+
+	statements, err := c.env.CollectStatements(ctx, c.mark)
+	if err != nil {
+		c.tb.Fatalf("psql: %v", err)
+	}
+
+	return statements
+}
+
+// AssertCount fails the test unless want statements match pattern.
+func (c *Capture) AssertCount(pattern string, want int) {
+	c.tb.Helper()
+
+	if got := c.Statements().Match(pattern); len(got) != want {
+		c.tb.Errorf("psql: %d statements match %q, want %d:\n%s", len(got), pattern, want, got)
+	}
+}
+
+// AssertAtMost fails the test when more than limit statements match
+// pattern, e.g. to detect N+1 queries.
+func (c *Capture) AssertAtMost(pattern string, limit int) {
+	c.tb.Helper()
+
+	if got := c.Statements().Match(pattern); len(got) > limit {
+		c.tb.Errorf("psql: %d statements match %q, want at most %d:\n%s", len(got), pattern, limit, got)
+	}
+}
+
+// Match returns the statements whose SQL matches the regular expression.
+// It panics on an invalid pattern.
+func (s Statements) Match(pattern string) Statements {
+	re := regexp.MustCompile(pattern)
+
+	var matched Statements
+
+	for _, stmt := range s {
+		if re.MatchString(stmt.SQL) {
+			matched = append(matched, stmt)
+		}
+	}
+
+	return matched
+}
+
+// SQL returns the statement texts.
+func (s Statements) SQL() []string {
+	texts := make([]string, len(s))
+	for i, stmt := range s {
+		texts[i] = stmt.SQL
+	}
+
+	return texts
+}
+
+// String lists the statements one per line, for test failure messages.
+func (s Statements) String() string {
+	var b strings.Builder
+
+	for _, stmt := range s {
+		fmt.Fprintf(&b, "  [%s %s] %s", stmt.Database, stmt.Duration, stmt.SQL)
+
+		if stmt.Params != "" {
+			b.WriteString(" -- " + stmt.Params)
+		}
+
+		b.WriteByte('\n')
+	}
+
+	return b.String()
+}
+
+func newRecorder() *recorder {
+	return &recorder{last: -1, pending: map[int]int{}, changed: make(chan struct{})}
+}
+
+// Accept implements testcontainers.LogConsumer.
+func (r *recorder) Accept(l testcontainers.Log) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.partial = append(r.partial, l.Content...)
+
+	added := false
+
+	for {
+		i := bytes.IndexByte(r.partial, '\n')
+		if i < 0 {
+			break
+		}
+
+		added = r.parse(strings.TrimSuffix(string(r.partial[:i]), "\r")) || added
+		r.partial = r.partial[i+1:]
+	}
+
+	if added {
+		close(r.changed)
+		r.changed = make(chan struct{})
+	}
+}
+
+// parse handles one log line and reports whether it added a statement.
+func (r *recorder) parse(line string) bool {
+	rest, ok := strings.CutPrefix(line, capturePrefix)
+	if !ok {
+		// continuation of a multi-line statement
+		if r.last >= 0 {
+			r.statements[r.last].SQL += "\n" + line
+		}
+
+		return false
+	}
+
+	r.last = -1
+
+	fields := strings.SplitN(rest, "|", 4)
+	if len(fields) < 4 {
+		return false
+	}
+
+	pid, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return false
+	}
+
+	message := fields[3]
+
+	switch {
+	case strings.HasPrefix(message, logStatement), strings.HasPrefix(message, logExecute):
+		sql := strings.TrimPrefix(message, logStatement)
+		if name, ok := strings.CutPrefix(message, logExecute); ok {
+			// <name>: text
+			_, sql, _ = strings.Cut(name, ": ")
+		}
+
+		r.statements = append(r.statements, Statement{SQL: sql, Database: fields[1], User: fields[2], PID: pid})
+		r.last = len(r.statements) - 1
+		r.pending[pid] = r.last
+
+		return true
+	case strings.HasPrefix(message, logParams):
+		if i, ok := r.pending[pid]; ok {
+			r.statements[i].Params = strings.TrimPrefix(message, logParams)
+		}
+	case strings.HasPrefix(message, logDuration):
+		// durations of parse and bind are logged before the statement, only
+		// the first one after it belongs to the statement
+		if i, ok := r.pending[pid]; ok {
+			delete(r.pending, pid)
+
+			value := strings.TrimSuffix(strings.TrimPrefix(message, logDuration), " ms")
+			if ms, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+				r.statements[i].Duration = time.Duration(ms * float64(time.Millisecond))
+			}
+		}
+	}
+
+	return false
+}
+
+// wait returns the index of the first statement containing marker once it
+// has been logged.
+func (r *recorder) wait(ctx context.Context, marker string) (int, error) {
+	from := 0
+
+	for {
+		r.mu.Lock()
+		for i := from; i < len(r.statements); i++ {
+			if strings.Contains(r.statements[i].SQL, "'"+marker+"'") {
+				r.mu.Unlock()
+				return i, nil
+			}
+		}
+
+		from = len(r.statements)
+		changed := r.changed
+		r.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return 0, errors.Wrapf(ctx.Err(), "wait for %s in the server log", marker)
+		case <-changed:
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package psql
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	testcontainers "github.com/testcontainers/testcontainers-go"
+)
+
+func TestRecorder(t *testing.T) {
+	rec := newRecorder()
+
+	logs := []string{
+		"goat|10|app|app|LOG:  statement: SELECT 'goat-capture-1'\n",
+		"goat|10|app|app|LOG:  duration: 0.120 ms\n",
+		"goat|11|app|svc|LOG:  duration: 0.010 ms\n", // parse, before the statement
+		"goat|11|app|svc|LOG:  execute <unnamed>: SELECT * FROM users WHERE id = $1\n",
+		"goat|11|app|svc|DETAIL:  parameters: $1 = '42'\n",
+		"goat|11|app|svc|LOG:  duration: 1.500 ms\n",
+		"goat|12|billing|svc|LOG:  statement: UPDATE orders\n\tSET paid = true\n",
+		"goat|12|bill", // a line split across log frames
+		"ing|svc|LOG:  execute S_1: SELECT * FROM orders\n",
+		"goat|1|||LOG:  checkpoint starting: time\n",
+	}
+
+	for _, content := range logs {
+		rec.Accept(testcontainers.Log{LogType: testcontainers.StderrLog, Content: []byte(content)})
+	}
+
+	want := []string{
+		"SELECT 'goat-capture-1'",
+		"SELECT * FROM users WHERE id = $1",
+		"UPDATE orders\n\tSET paid = true",
+		"SELECT * FROM orders",
+	}
+
+	if got := rec.statements.SQL(); !slices.Equal(got, want) {
+		t.Fatalf("statements = %q, want %q", got, want)
+	}
+
+	users := rec.statements[1]
+	if users.Params != "$1 = '42'" || users.Duration != 1500*time.Microsecond || users.User != "svc" || users.PID != 11 {
+		t.Errorf("prepared statement = %+v", users)
+	}
+
+	if orders := rec.statements[3]; orders.Database != "billing" || orders.Duration != 0 {
+		t.Errorf("statement split across frames = %+v", orders)
+	}
+
+	if got := rec.statements.Match(`(?i)^select .* from (users|orders)`); len(got) != 2 {
+		t.Errorf("Match() = %q, want 2 statements", got.SQL())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if i, err := rec.wait(ctx, "goat-capture-1"); err != nil || i != 0 {
+		t.Errorf("wait() = %d, %v, want 0", i, err)
+	}
+}
+
+func TestRecorderWaitsForMarker(t *testing.T) {
+	rec := newRecorder()
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		rec.Accept(testcontainers.Log{Content: []byte("goat|1|app|app|LOG:  statement: SELECT 'goat-capture-12'\n")})
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	if _, err := rec.wait(ctx, "goat-capture-1"); err == nil {
+		t.Errorf("wait() matched a longer marker")
+	}
+
+	rec = newRecorder()
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		rec.Accept(testcontainers.Log{Content: []byte("goat|1|app|app|LOG:  statement: SELECT 'goat-capture-1'\n")})
+	}()
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if i, err := rec.wait(ctx, "goat-capture-1"); err != nil || i != 0 {
+		t.Errorf("wait() = %d, %v, want 0", i, err)
+	}
+}
+
+func TestWithStatementCapture(t *testing.T) {
+	opt := WithStatementCapture()
+	req := testcontainers.GenericContainerRequest{}
+
+	if err := opt.Customize(&req); err != nil {
+		t.Fatalf("Customize() error = %v", err)
+	}
+
+	if !slices.Contains(req.Cmd, "log_statement=all") || !slices.Contains(req.Cmd, "log_line_prefix="+captureLinePrefix) {
+		t.Errorf("Cmd = %v", req.Cmd)
+	}
+
+	if req.LogConsumerCfg == nil || len(req.LogConsumerCfg.Consumers) != 1 {
+		t.Fatalf("recorder is not a log consumer")
+	}
+
+	if collectOptions([]testcontainers.ContainerCustomizer{opt}).recorder == nil {
+		t.Errorf("collectOptions() lost the recorder")
+	}
+
+	if _, err := (&Env{}).MarkStatements(context.Background()); err == nil {
+		t.Errorf("MarkStatements() without capture expected error")
+	}
+}
@@ -0,0 +1,258 @@
+package psql
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	errors "github.com/go-faster/errors"
+	testcontainers "github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/network"
+
+	common "github.com/Educentr/goat-services/common"
+)
+
+const (
+	primaryAlias  = "primary"
+	replicaPrefix = "replica-"
+
+	replicationPollInterval = 50 * time.Millisecond
+
+	// hbaScript allows replication connections, which the "all" database of
+	// the image's pg_hba.conf does not match.
+	hbaScript = `#!/bin/sh
+echo "host replication all all md5" >> "$PGDATA/pg_hba.conf"
+`
+	hbaScriptPath = "/docker-entrypoint-initdb.d/00-replication.sh"
+
+	// replicaScript clones the primary and starts the server as a hot standby;
+	// the server arguments of the image command arrive as "$0" "$@".
+	replicaScript = `set -e
+pg_basebackup --pgdata="$PGDATA" --host="$PRIMARY_HOST" --port=5432 --username="$POSTGRES_USER" \
+	--wal-method=stream --write-recovery-conf --checkpoint=fast
+exec docker-entrypoint.sh "$0" "$@"
+`
+)
+
+type (
+	// Cluster is a primary with streaming replicas on a shared network.
+	Cluster struct {
+		Primary  *Env
+		Replicas []*Env
+
+		// Network is the name of the cluster network; the primary is reachable
+		// there as "primary" and the replicas as "replica-1", "replica-2", ...
+		Network string
+
+		removeNetwork func(ctx context.Context) error
+	}
+)
+
+// RunCluster starts a primary with the given customizers and the number of
+// streaming replicas cloned from it with pg_basebackup. Replicas use the
+// image, credentials and server settings of the primary, including those of
+// WithSettings, WithFastProfile and WithExtensions; their Env is read-only.
+func RunCluster(ctx context.Context, replicas int, opts ...testcontainers.ContainerCustomizer) (*Cluster, error) {
+	if replicas < 1 {
+		return nil, errors.Errorf("cluster needs at least one replica, got %d", replicas)
+	}
+
+	nw, err := network.New(ctx, network.WithLabels(common.SessionLabels()))
+	if err != nil {
+		return nil, errors.Wrap(err, "create network")
+	}
+
+	c := &Cluster{Network: nw.Name, removeNetwork: nw.Remove}
+
+	if err := c.start(ctx, replicas, opts); err != nil {
+		// ctx may be canceled at this point, cleanup must not depend on it
+		return nil, errors.Join(err, c.Terminate(context.WithoutCancel(ctx)))
+	}
+
+	return c, nil
+}
+
+func (c *Cluster) start(ctx context.Context, replicas int, opts []testcontainers.ContainerCustomizer) error {
+	primaryOpts := append([]testcontainers.ContainerCustomizer{
+		WithSettings(map[string]string{
+			"wal_level":       "replica",
+			"max_wal_senders": strconv.Itoa(replicas + 2),
+		}),
+	}, opts...)
+	primaryOpts = append(primaryOpts,
+		testcontainers.CustomizeRequestOption(func(req *testcontainers.GenericContainerRequest) error {
+			req.Files = append(req.Files, testcontainers.ContainerFile{
+				Reader:            strings.NewReader(hbaScript),
+				ContainerFilePath: hbaScriptPath,
+				FileMode:          0o755,
+			})
+
+			return nil
+		}),
+		common.WithNetworkAlias(c.Network, primaryAlias),
+	)
+
+	primary, err := Run(ctx, primaryOpts...)
+	if err != nil {
+		return errors.Wrap(err, "start primary")
+	}
+
+	c.Primary = primary
+
+	for i := 1; i <= replicas; i++ {
+		replica, err := Run(ctx, replicaOptions(primary, primary.image, c.Network, replicaPrefix+strconv.Itoa(i))...)
+		if err != nil {
+			return errors.Wrapf(err, "start replica %d", i)
+		}
+
+		c.Replicas = append(c.Replicas, replica)
+	}
+
+	return nil
+}
+
+// replicaOptions returns the customizers of a replica of primary.
+func replicaOptions(primary *Env, image, networkName, alias string) []testcontainers.ContainerCustomizer {
+	env := map[string]string{
+		userNameEnvKey: primary.DBUser,
+		userPassEnvKey: primary.DBPass,
+		dbNameEnvKey:   primary.DBName,
+		"PGPASSWORD":   primary.DBPass,
+		"PRIMARY_HOST": primaryAlias,
+	}
+
+	return []testcontainers.ContainerCustomizer{
+		testcontainers.WithImage(image),
+		testcontainers.WithEnv(env),
+		testcontainers.WithEntrypoint("sh", "-c", replicaScript),
+		// a hot standby refuses to start with settings such as max_connections
+		// lower than the primary's, and needs its preload libraries
+		testcontainers.CustomizeRequestOption(func(req *testcontainers.GenericContainerRequest) error {
+			if len(primary.cmd) > 0 {
+				req.Cmd = slices.Clone(primary.cmd)
+			}
+
+			return nil
+		}),
+		// pg_basebackup must write the data directory as the server user
+		testcontainers.WithConfigModifier(func(config *container.Config) {
+			config.User = "postgres"
+		}),
+		testcontainers.WithWaitStrategy(waitForSQL(postgresPort, primary.dsn("", "", primary.DBName))),
+		WithConnParams(primary.params),
+		common.WithNetworkAlias(networkName, alias),
+	}
+}
+
+// ReplicaURIs returns the connection URIs of the replicas.
+func (c *Cluster) ReplicaURIs() []string {
+	uris := make([]string, 0, len(c.Replicas))
+	for _, replica := range c.Replicas {
+		uris = append(uris, replica.URI)
+	}
+
+	return uris
+}
+
+// WaitForReplication waits until every replica has replayed the WAL written
+// by the primary up to now. A paused replica makes it wait until ctx is done.
+func (c *Cluster) WaitForReplication(ctx context.Context) error {
+	db, err := c.Primary.SQL()
+	if err != nil {
+		return err
+	}
+
+	var lsn string
+	if err := db.QueryRowContext(ctx, "SELECT pg_current_wal_lsn()::text").Scan(&lsn); err != nil {
+		return errors.Wrap(err, "read primary LSN")
+	}
+
+	for _, replica := range c.Replicas {
+		if err := replica.WaitForLSN(ctx, lsn); err != nil {
+			return errors.Wrapf(err, "replica %s", replica.ServiceName())
+		}
+	}
+
+	return nil
+}
+
+// Terminate stops the replicas and the primary and removes the network.
+func (c *Cluster) Terminate(ctx context.Context) error {
+	var errs []error
+
+	for i := len(c.Replicas) - 1; i >= 0; i-- {
+		if err := c.Replicas[i].Terminate(ctx); err != nil {
+			errs = append(errs, errors.Wrapf(err, "terminate %s", c.Replicas[i].ServiceName()))
+		}
+	}
+
+	if c.Primary != nil {
+		if err := c.Primary.Terminate(ctx); err != nil {
+			errs = append(errs, errors.Wrap(err, "terminate primary"))
+		}
+	}
+
+	if c.removeNetwork != nil {
+		if err := c.removeNetwork(ctx); err != nil {
+			errs = append(errs, errors.Wrap(err, "remove network"))
+		}
+	}
+
+	c.Replicas, c.Primary, c.removeNetwork = nil, nil, nil
+
+	return errors.Join(errs...)
+}
+
+// PauseReplication stops a replica from applying WAL, so reads from it see
+// the data as of the pause, e.g. to test replica lag handling. WAL is still
+// received and applied after ResumeReplication.
+func (e *Env) PauseReplication(ctx context.Context) error {
+	return e.execReplay(ctx, "SELECT pg_wal_replay_pause()")
+}
+
+// ResumeReplication resumes applying WAL on a replica paused by PauseReplication.
+func (e *Env) ResumeReplication(ctx context.Context) error {
+	return e.execReplay(ctx, "SELECT pg_wal_replay_resume()")
+}
+
+// WaitForLSN waits until a replica has replayed WAL up to lsn, as returned
+// by pg_current_wal_lsn on the primary.
+func (e *Env) WaitForLSN(ctx context.Context, lsn string) error {
+	db, err := e.SQL()
+	if err != nil {
+		return err
+	}
+
+	for {
+		var replayed bool
+
+		err := db.QueryRowContext(ctx, "SELECT COALESCE(pg_last_wal_replay_lsn() >= $1::pg_lsn, false)", lsn).Scan(&replayed)
+		if err != nil {
+			return errors.Wrap(err, "read replay LSN")
+		}
+
+		if replayed {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return errors.Wrapf(ctx.Err(), "wait for LSN %s", lsn)
+		case <-time.After(replicationPollInterval):
+		}
+	}
+}
+
+func (e *Env) execReplay(ctx context.Context, query string) error {
+	db, err := e.SQL()
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx, query)
+
+	return err
+}
@@ -0,0 +1,101 @@
+package psql
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	testcontainers "github.com/testcontainers/testcontainers-go"
+
+	common "github.com/Educentr/goat-services/common"
+)
+
+func TestReplicaOptions(t *testing.T) {
+	primary := &Env{DBUser: "u", DBPass: "p", DBName: "orders"}
+	req := testcontainers.GenericContainerRequest{}
+
+	for _, opt := range replicaOptions(primary, "postgres:16-alpine", "net", "replica-1") {
+		if err := opt.Customize(&req); err != nil {
+			t.Fatalf("Customize() error = %v", err)
+		}
+	}
+
+	if req.Image != "postgres:16-alpine" {
+		t.Errorf("Image = %q", req.Image)
+	}
+
+	if req.Env[userNameEnvKey] != "u" || req.Env["PGPASSWORD"] != "p" || req.Env["PRIMARY_HOST"] != primaryAlias {
+		t.Errorf("Env = %v", req.Env)
+	}
+
+	if len(req.Entrypoint) != 3 || req.Entrypoint[2] != replicaScript {
+		t.Errorf("Entrypoint = %v", req.Entrypoint)
+	}
+
+	config := &container.Config{}
+	req.ConfigModifier(config)
+
+	if config.User != "postgres" {
+		t.Errorf("User = %q, want postgres", config.User)
+	}
+
+	if alias := common.NetworkAlias(&req); alias != "replica-1" {
+		t.Errorf("NetworkAlias = %q, want replica-1", alias)
+	}
+}
+
+func TestReplicaOptionsCopySettings(t *testing.T) {
+	var primaryReq testcontainers.GenericContainerRequest
+
+	_, err := common.Customize(kind, &primaryReq,
+		WithSettings(map[string]string{"max_connections": "200"}),
+		withExtensionNeeds([]string{"pg_stat_statements"}),
+	)
+	if err != nil {
+		t.Fatalf("Customize() error = %v", err)
+	}
+
+	primary := &Env{DBUser: "u", DBPass: "p", DBName: "orders", cmd: primaryReq.Cmd}
+	req := testcontainers.GenericContainerRequest{}
+	req.Cmd = []string{"postgres", "-c", "fsync=off"}
+
+	for _, opt := range replicaOptions(primary, "postgres:16-alpine", "net", "replica-1") {
+		if err := opt.Customize(&req); err != nil {
+			t.Fatalf("Customize() error = %v", err)
+		}
+	}
+
+	for _, want := range []string{"max_connections=200", "shared_preload_libraries=pg_stat_statements"} {
+		if !slices.Contains(req.Cmd, want) {
+			t.Errorf("Cmd = %v, want %s", req.Cmd, want)
+		}
+	}
+}
+
+func TestClusterReplicaSettings(t *testing.T) {
+	testcontainers.SkipIfProviderIsNotHealthy(t)
+
+	ctx := context.Background()
+
+	c, err := RunCluster(ctx, 1, WithSettings(map[string]string{"max_connections": "200"}))
+	if err != nil {
+		t.Fatalf("RunCluster() error = %v", err)
+	}
+
+	t.Cleanup(func() { _ = c.Terminate(ctx) })
+
+	db, err := c.Replicas[0].SQL()
+	if err != nil {
+		t.Fatalf("SQL() error = %v", err)
+	}
+
+	var maxConnections string
+	if err := db.QueryRowContext(ctx, "SHOW max_connections").Scan(&maxConnections); err != nil {
+		t.Fatalf("SHOW max_connections: %v", err)
+	}
+
+	if maxConnections != "200" {
+		t.Errorf("replica max_connections = %s, want 200", maxConnections)
+	}
+}
@@ -0,0 +1,178 @@
+package psql
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"strings"
+
+	errors "github.com/go-faster/errors"
+	pq "github.com/lib/pq"
+	testcontainers "github.com/testcontainers/testcontainers-go"
+
+	common "github.com/Educentr/goat-services/common"
+)
+
+const preloadSetting = "shared_preload_libraries"
+
+type (
+	// extension lists what an extension needs beyond CREATE EXTENSION.
+	extension struct {
+		flavor  string // catalog service of an image shipping the extension
+		preload string // library for shared_preload_libraries
+	}
+
+	// extensionsOption is returned by WithExtensions. Like Option it does not
+	// change the request itself, Run combines the extensions of every call
+	// with withExtensionNeeds.
+	extensionsOption []string
+)
+
+// extensions are the extensions that need more than the stock image.
+// Contrib extensions such as pg_trgm, citext or pgcrypto need no entry.
+var extensions = map[string]extension{
+	"postgis":                {flavor: "postgres-postgis"},
+	"postgis_raster":         {flavor: "postgres-postgis"},
+	"postgis_tiger_geocoder": {flavor: "postgres-postgis"},
+	"postgis_topology":       {flavor: "postgres-postgis"},
+	"vector":                 {flavor: "postgres-pgvector"},
+	"timescaledb":            {flavor: "postgres-timescaledb", preload: "timescaledb"},
+	"pg_stat_statements":     {preload: "pg_stat_statements"},
+	"pg_prewarm":             {preload: "pg_prewarm"},
+}
+
+// WithExtensions creates the extensions in the database before migrations
+// run. When an extension is missing from the stock image, Run switches to the
+// catalog image flavor shipping it (postgres-postgis, postgres-pgvector or
+// postgres-timescaledb, see common.SetImage) unless an image is set, and
+// libraries that must be preloaded are added to shared_preload_libraries.
+// Run fails when an extension cannot be created.
+func WithExtensions(names ...string) testcontainers.ContainerCustomizer {
+	return extensionsOption(names)
+}
+
+// Customize implements testcontainers.ContainerCustomizer.
+func (extensionsOption) Customize(*testcontainers.GenericContainerRequest) error { return nil }
+
+// HashKey implements common.HashKeyer.
+func (o extensionsOption) HashKey() (string, error) {
+	return (&options{extensions: o}).hashKey()
+}
+
+// withExtensionNeeds sets the image flavor unless an image is set and merges
+// the preload libraries into shared_preload_libraries, keeping libraries set
+// with WithSettings. Run applies it after the other customizers.
+func withExtensionNeeds(names []string) testcontainers.CustomizeRequestOption {
+	return func(req *testcontainers.GenericContainerRequest) error {
+		flavor, preload, err := extensionNeeds(names)
+		if err != nil {
+			return err
+		}
+
+		if flavor != "" && req.Image == "" {
+			if req.Image, err = common.ResolveImage(flavor); err != nil {
+				return err
+			}
+		}
+
+		if len(preload) == 0 {
+			return nil
+		}
+
+		var libraries []string
+
+		req.Cmd, libraries = cutSetting(req.Cmd, preloadSetting)
+		for _, lib := range preload {
+			if !slices.Contains(libraries, lib) {
+				libraries = append(libraries, lib)
+			}
+		}
+
+		return WithSettings(map[string]string{preloadSetting: strings.Join(libraries, ",")})(req)
+	}
+}
+
+// cutSetting removes every -c name=value and --name=value argument of the
+// setting from cmd and returns the comma separated values.
+func cutSetting(cmd []string, name string) ([]string, []string) {
+	var (
+		kept   = make([]string, 0, len(cmd))
+		values []string
+	)
+
+	for i := 0; i < len(cmd); i++ {
+		arg := cmd[i]
+		if arg == "-c" && i+1 < len(cmd) {
+			arg = "--" + cmd[i+1]
+		}
+
+		value, ok := strings.CutPrefix(arg, "--"+name+"=")
+		if !ok {
+			kept = append(kept, cmd[i])
+			continue
+		}
+
+		if cmd[i] == "-c" {
+			i++
+		}
+
+		for _, v := range strings.Split(strings.Trim(value, `'"`), ",") {
+			if v = strings.TrimSpace(v); v != "" && !slices.Contains(values, v) {
+				values = append(values, v)
+			}
+		}
+	}
+
+	return kept, values
+}
+
+// extensionNeeds returns the image flavor and the preload libraries of the
+// extensions. Extensions from different flavors cannot be combined.
+func extensionNeeds(names []string) (string, []string, error) {
+	var (
+		flavor, flavorOf string
+		preload          []string
+	)
+
+	for _, name := range names {
+		ext := extensions[name]
+
+		if ext.flavor != "" {
+			if flavor != "" && flavor != ext.flavor {
+				return "", nil, errors.Errorf(
+					"extensions %s and %s need different images (%s, %s), set an image shipping both with testcontainers.WithImage",
+					flavorOf, name, flavor, ext.flavor,
+				)
+			}
+
+			flavor, flavorOf = ext.flavor, name
+		}
+
+		if ext.preload != "" && !slices.Contains(preload, ext.preload) {
+			preload = append(preload, ext.preload)
+		}
+	}
+
+	return flavor, preload, nil
+}
+
+// createExtensions creates the extensions with their dependencies.
+func (e *Env) createExtensions(ctx context.Context, names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+
+	db, err := sql.Open("postgres", e.databaseURI(e.DBName))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	for _, name := range names {
+		if _, err := db.ExecContext(ctx, "CREATE EXTENSION IF NOT EXISTS "+pq.QuoteIdentifier(name)+" CASCADE"); err != nil {
+			return errors.Wrapf(err, "create extension %s in image %s", name, e.image)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,124 @@
+package psql
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	testcontainers "github.com/testcontainers/testcontainers-go"
+)
+
+func TestWithExtensions(t *testing.T) {
+	tests := []struct {
+		name       string
+		extensions []string
+		image      string
+		wantImage  string
+		wantCmd    []string
+		wantErr    string
+	}{
+		{
+			name:       "stock",
+			extensions: []string{"pg_trgm", "citext"},
+		},
+		{
+			name:       "flavor and preload",
+			extensions: []string{"timescaledb", "pg_stat_statements"},
+			wantImage:  "timescale/timescaledb",
+			wantCmd:    []string{"postgres", "-c", "shared_preload_libraries=timescaledb,pg_stat_statements"},
+		},
+		{
+			name:       "explicit image wins",
+			extensions: []string{"vector"},
+			image:      "example/pg:16",
+			wantImage:  "example/pg:16",
+		},
+		{
+			name:       "conflicting flavors",
+			extensions: []string{"postgis", "vector"},
+			wantErr:    "need different images",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := testcontainers.GenericContainerRequest{}
+			req.Image = tt.image
+
+			err := withExtensionNeeds(tt.extensions)(&req)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("withExtensionNeeds() error = %v, want %q", err, tt.wantErr)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("withExtensionNeeds() error = %v", err)
+			}
+
+			if !strings.Contains(req.Image, tt.wantImage) || (tt.wantImage == "") != (req.Image == "") {
+				t.Errorf("Image = %q, want %q", req.Image, tt.wantImage)
+			}
+
+			if !slices.Equal(req.Cmd, tt.wantCmd) {
+				t.Errorf("Cmd = %v, want %v", req.Cmd, tt.wantCmd)
+			}
+
+			if got := collectOptions([]testcontainers.ContainerCustomizer{WithExtensions(tt.extensions...)}).extensions; !slices.Equal(got, tt.extensions) {
+				t.Errorf("collected extensions = %v, want %v", got, tt.extensions)
+			}
+		})
+	}
+}
+
+func TestWithExtensionsMergesPreload(t *testing.T) {
+	opts := []testcontainers.ContainerCustomizer{
+		WithExtensions("timescaledb"),
+		WithSettings(map[string]string{"shared_preload_libraries": "auto_explain,pg_stat_statements"}),
+		WithExtensions("pg_stat_statements", "pg_prewarm"),
+		WithFastProfile(),
+	}
+
+	req := testcontainers.GenericContainerRequest{}
+	for _, opt := range append(opts, withExtensionNeeds(collectOptions(opts).extensions)) {
+		if err := opt.Customize(&req); err != nil {
+			t.Fatalf("Customize() error = %v", err)
+		}
+	}
+
+	var preload []string
+	for i, arg := range req.Cmd {
+		if strings.HasPrefix(arg, "shared_preload_libraries=") {
+			if req.Cmd[i-1] != "-c" {
+				t.Errorf("Cmd = %v, want -c before %s", req.Cmd, arg)
+			}
+
+			preload = append(preload, arg)
+		}
+	}
+
+	want := "shared_preload_libraries=auto_explain,pg_stat_statements,timescaledb,pg_prewarm"
+	if !slices.Equal(preload, []string{want}) {
+		t.Errorf("preload settings = %v, want [%s]", preload, want)
+	}
+
+	if !slices.Contains(req.Cmd, "fsync=off") {
+		t.Errorf("Cmd = %v lost the other settings", req.Cmd)
+	}
+}
+
+func TestCutSetting(t *testing.T) {
+	cmd := []string{"postgres", "-c", "fsync=off", "-c", "shared_preload_libraries='a, b'", "--shared_preload_libraries=c"}
+
+	kept, values := cutSetting(cmd, "shared_preload_libraries")
+
+	if want := []string{"postgres", "-c", "fsync=off"}; !slices.Equal(kept, want) {
+		t.Errorf("kept = %v, want %v", kept, want)
+	}
+
+	if want := []string{"a", "b", "c"}; !slices.Equal(values, want) {
+		t.Errorf("values = %v, want %v", values, want)
+	}
+}
@@ -0,0 +1,375 @@
+package psql
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"maps"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	errors "github.com/go-faster/errors"
+	pq "github.com/lib/pq"
+	yaml "gopkg.in/yaml.v3"
+)
+
+type (
+	// fixtureRows maps a table name, optionally schema-qualified, to its rows.
+	fixtureRows map[string][]map[string]any
+
+	sqlFixture struct {
+		file       string
+		statements []statement
+	}
+)
+
+// LoadFixtures inserts fixture files of fsys in one transaction using the
+// connection of SQL. Without files every .yml, .yaml, .json and .sql file of
+// the fsys root is loaded.
+//
+// YAML and JSON files map table names to lists of rows:
+//
+//	users:
+//	  - id: 1
+//	    name: alice
+//	orders:
+//	  - user_id: 1
+//	    payload: {items: 3} # maps and lists are stored as JSON
+//
+// Rows of all data files are inserted tables first that others reference,
+// whatever the file order, and the sequences of the filled tables are moved
+// past the inserted ids. SQL files run afterwards in the given or name order.
+func (e *Env) LoadFixtures(ctx context.Context, fsys fs.FS, files ...string) error {
+	if len(files) == 0 {
+		var err error
+		if files, err = fixtureFiles(fsys); err != nil {
+			return err
+		}
+	}
+
+	rows := fixtureRows{}
+
+	var scripts []sqlFixture
+
+	for _, file := range files {
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return err
+		}
+
+		if strings.EqualFold(path.Ext(file), migrationExt) {
+			scripts = append(scripts, sqlFixture{file: file, statements: splitStatements(string(data), 1)})
+			continue
+		}
+
+		tables, err := parseFixture(file, data)
+		if err != nil {
+			return errors.Wrapf(err, "fixture %s", file)
+		}
+
+		for table, r := range tables {
+			rows[table] = append(rows[table], r...)
+		}
+	}
+
+	db, err := e.SQL()
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck // no-op after commit
+
+	if err := insertFixtures(ctx, tx, rows); err != nil {
+		return err
+	}
+
+	for _, script := range scripts {
+		for i, stmt := range script.statements {
+			if _, err := tx.ExecContext(ctx, stmt.sql); err != nil {
+				return errors.Wrapf(err, "fixture %s: statement %d at line %s", script.file, i+1, errorLine(stmt, err))
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Truncate empties every table of the database with TRUNCATE ... RESTART
+// IDENTITY CASCADE using the connection of SQL. The schema, the migration
+// bookkeeping tables and the tables owned by extensions are kept, so it is a
+// faster alternative to Reset between tests.
+func (e *Env) Truncate(ctx context.Context) error {
+	db, err := e.SQL()
+	if err != nil {
+		return err
+	}
+
+	// tables that belong to an extension (PostGIS spatial_ref_sys,
+	// _timescaledb_catalog.*) hold its configuration, not test data
+	tables, err := queryStrings(ctx, db,
+		`SELECT format('%I.%I', n.nspname, c.relname) FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE c.relkind IN ('r', 'p') AND n.nspname NOT LIKE 'pg\_%' AND n.nspname <> 'information_schema'
+		AND c.relname <> ALL($1)
+		AND NOT EXISTS (SELECT 1 FROM pg_depend d
+			WHERE d.classid = 'pg_class'::regclass AND d.objid = c.oid AND d.deptype = 'e')`,
+		pq.Array(bookkeepingTables),
+	)
+	if err != nil {
+		return errors.Wrap(err, "list tables")
+	}
+
+	if len(tables) == 0 {
+		return nil
+	}
+
+	_, err = db.ExecContext(ctx, "TRUNCATE TABLE "+strings.Join(tables, ", ")+" RESTART IDENTITY CASCADE")
+
+	return err
+}
+
+func fixtureFiles(fsys fs.FS) ([]string, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+
+	for _, entry := range entries {
+		switch strings.ToLower(path.Ext(entry.Name())) {
+		case ".yml", ".yaml", ".json", migrationExt:
+			if !entry.IsDir() {
+				files = append(files, entry.Name())
+			}
+		}
+	}
+
+	return files, nil
+}
+
+func parseFixture(file string, data []byte) (fixtureRows, error) {
+	var rows fixtureRows
+
+	switch strings.ToLower(path.Ext(file)) {
+	case ".yml", ".yaml":
+		if err := yaml.Unmarshal(data, &rows); err != nil {
+			return nil, err
+		}
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber() // keep bigint ids exact
+
+		if err := dec.Decode(&rows); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("unsupported format")
+	}
+
+	for table, list := range rows {
+		for i, row := range list {
+			for column, value := range row {
+				switch value.(type) {
+				case map[string]any, []any:
+					b, err := json.Marshal(value)
+					if err != nil {
+						return nil, errors.Wrapf(err, "%s row %d column %s", table, i+1, column)
+					}
+
+					row[column] = string(b)
+				}
+			}
+		}
+	}
+
+	return rows, nil
+}
+
+// insertFixtures inserts the rows in foreign key order and advances the
+// sequences of the tables.
+func insertFixtures(ctx context.Context, tx *sql.Tx, rows fixtureRows) error {
+	if len(rows) == 0 {
+		return nil
+	}
+
+	// resolve the names the way PostgreSQL does, so "Users" and users differ
+	// and unqualified names follow search_path
+	names := make(map[string]string, len(rows))
+	oids := make(map[int64]string, len(rows))
+
+	for table := range rows {
+		var (
+			oid  sql.NullInt64
+			name sql.NullString
+		)
+
+		err := tx.QueryRowContext(ctx, `SELECT c::oid::bigint, c::text FROM to_regclass($1) AS c`, table).Scan(&oid, &name)
+		if err != nil {
+			return errors.Wrapf(err, "resolve table %s", table)
+		}
+
+		if !oid.Valid {
+			return errors.Errorf("fixture table %s does not exist", table)
+		}
+
+		names[table] = name.String
+		oids[oid.Int64] = table
+	}
+
+	deps := map[string][]string{}
+
+	refs, err := tx.QueryContext(ctx, `SELECT conrelid::bigint, confrelid::bigint FROM pg_constraint WHERE contype = 'f'`)
+	if err != nil {
+		return errors.Wrap(err, "list foreign keys")
+	}
+
+	for refs.Next() {
+		var from, to int64
+		if err := refs.Scan(&from, &to); err != nil {
+			return errors.Join(err, refs.Close())
+		}
+
+		if child, ok := oids[from]; ok {
+			if parent, ok := oids[to]; ok && parent != child {
+				deps[child] = append(deps[child], parent)
+			}
+		}
+	}
+
+	if err := errors.Join(refs.Err(), refs.Close()); err != nil {
+		return err
+	}
+
+	for _, table := range orderTables(slices.Collect(maps.Keys(rows)), deps) {
+		name := names[table]
+
+		for i, row := range rows[table] {
+			if len(row) == 0 {
+				continue
+			}
+
+			query, args := insertQuery(name, row)
+			if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+				return errors.Wrapf(err, "insert %s row %d", table, i+1)
+			}
+		}
+
+		if err := syncSequences(ctx, tx, name); err != nil {
+			return errors.Wrapf(err, "sync sequences of %s", table)
+		}
+	}
+
+	return nil
+}
+
+// orderTables sorts tables so that the ones referenced by deps come first.
+// Tables of a reference cycle keep name order.
+func orderTables(tables []string, deps map[string][]string) []string {
+	slices.Sort(tables)
+
+	var (
+		order = make([]string, 0, len(tables))
+		done  = make(map[string]bool, len(tables))
+	)
+
+	for len(order) < len(tables) {
+		progress := false
+
+		for _, table := range tables {
+			if done[table] {
+				continue
+			}
+
+			ready := true
+
+			for _, dep := range deps[table] {
+				if !done[dep] && slices.Contains(tables, dep) {
+					ready = false
+					break
+				}
+			}
+
+			if ready {
+				order = append(order, table)
+				done[table] = true
+				progress = true
+			}
+		}
+
+		if !progress {
+			for _, table := range tables {
+				if !done[table] {
+					order = append(order, table)
+					done[table] = true
+				}
+			}
+		}
+	}
+
+	return order
+}
+
+func insertQuery(table string, row map[string]any) (string, []any) {
+	columns := slices.Sorted(maps.Keys(row))
+
+	quoted := make([]string, len(columns))
+	params := make([]string, len(columns))
+	args := make([]any, len(columns))
+
+	for i, column := range columns {
+		quoted[i] = pq.QuoteIdentifier(column)
+		params[i] = "$" + strconv.Itoa(i+1)
+		args[i] = row[column]
+	}
+
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table, strings.Join(quoted, ", "), strings.Join(params, ", ")), args
+}
+
+// syncSequences moves serial and identity sequences of the table past the
+// largest value, so rows inserted by the test after explicit fixture ids do
+// not collide.
+func syncSequences(ctx context.Context, tx *sql.Tx, table string) error {
+	rows, err := tx.QueryContext(ctx,
+		`SELECT attname, pg_get_serial_sequence($1, attname) FROM pg_attribute
+		WHERE attrelid = $1::regclass AND attnum > 0 AND NOT attisdropped
+		AND pg_get_serial_sequence($1, attname) IS NOT NULL`,
+		table,
+	)
+	if err != nil {
+		return err
+	}
+
+	sequences := map[string]string{}
+
+	for rows.Next() {
+		var column, sequence string
+		if err := rows.Scan(&column, &sequence); err != nil {
+			return errors.Join(err, rows.Close())
+		}
+
+		sequences[column] = sequence
+	}
+
+	if err := errors.Join(rows.Err(), rows.Close()); err != nil {
+		return err
+	}
+
+	for column, sequence := range sequences {
+		query := fmt.Sprintf("SELECT setval($1, COALESCE(MAX(%s), 0) + 1, false) FROM %s", pq.QuoteIdentifier(column), table)
+		if _, err := tx.ExecContext(ctx, query, sequence); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,161 @@
+package psql
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"testing"
+	"testing/fstest"
+
+	testcontainers "github.com/testcontainers/testcontainers-go"
+)
+
+func TestParseFixture(t *testing.T) {
+	yamlRows, err := parseFixture("users.yml", []byte(`
+users:
+  - id: 1
+    name: alice
+    settings: {theme: dark}
+    tags: [a, b]
+`))
+	if err != nil {
+		t.Fatalf("parseFixture(yaml) error = %v", err)
+	}
+
+	row := yamlRows["users"][0]
+	if row["id"] != 1 || row["name"] != "alice" {
+		t.Errorf("yaml row = %v", row)
+	}
+
+	if row["settings"] != `{"theme":"dark"}` || row["tags"] != `["a","b"]` {
+		t.Errorf("nested values are not JSON: settings = %v, tags = %v", row["settings"], row["tags"])
+	}
+
+	jsonRows, err := parseFixture("orders.JSON", []byte(`{"orders": [{"id": 9007199254740993, "note": null}]}`))
+	if err != nil {
+		t.Fatalf("parseFixture(json) error = %v", err)
+	}
+
+	row = jsonRows["orders"][0]
+	if row["id"] != json.Number("9007199254740993") || row["note"] != nil {
+		t.Errorf("json row = %v", row)
+	}
+
+	if _, err := parseFixture("users.csv", nil); err == nil {
+		t.Errorf("parseFixture(csv) expected error")
+	}
+}
+
+func TestFixtureFiles(t *testing.T) {
+	fsys := fstest.MapFS{
+		"b_orders.json":  {},
+		"a_users.yaml":   {},
+		"c_seed.sql":     {},
+		"README.md":      {},
+		"nested/x.yml":   {},
+		"d_payments.YML": {},
+	}
+
+	files, err := fixtureFiles(fsys)
+	if err != nil {
+		t.Fatalf("fixtureFiles() error = %v", err)
+	}
+
+	want := []string{"a_users.yaml", "b_orders.json", "c_seed.sql", "d_payments.YML"}
+	if !slices.Equal(files, want) {
+		t.Errorf("fixtureFiles() = %v, want %v", files, want)
+	}
+}
+
+func TestOrderTables(t *testing.T) {
+	tests := []struct {
+		name   string
+		tables []string
+		deps   map[string][]string
+		want   []string
+	}{
+		{
+			name:   "chain",
+			tables: []string{"items", "orders", "users"},
+			deps:   map[string][]string{"items": {"orders"}, "orders": {"users"}},
+			want:   []string{"users", "orders", "items"},
+		},
+		{
+			name:   "reference outside fixtures",
+			tables: []string{"orders"},
+			deps:   map[string][]string{"orders": {"users"}},
+			want:   []string{"orders"},
+		},
+		{
+			name:   "cycle",
+			tables: []string{"b", "a", "c"},
+			deps:   map[string][]string{"a": {"b"}, "b": {"a"}, "c": {"a"}},
+			want:   []string{"a", "b", "c"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := orderTables(tt.tables, tt.deps); !slices.Equal(got, tt.want) {
+				t.Errorf("orderTables() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInsertQuery(t *testing.T) {
+	query, args := insertQuery(`public."Users"`, map[string]any{"name": "alice", "id": 1})
+
+	if want := `INSERT INTO public."Users" ("id", "name") VALUES ($1, $2)`; query != want {
+		t.Errorf("insertQuery() = %q, want %q", query, want)
+	}
+
+	if !slices.Equal(args, []any{1, "alice"}) {
+		t.Errorf("insertQuery() args = %v", args)
+	}
+}
+
+func TestTruncateKeepsExtensionTables(t *testing.T) {
+	testcontainers.SkipIfProviderIsNotHealthy(t)
+
+	ctx := context.Background()
+
+	pg, err := Run(ctx, WithExtensions("postgis"))
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	t.Cleanup(func() { _ = pg.Terminate(ctx) })
+
+	db, err := pg.SQL()
+	if err != nil {
+		t.Fatalf("SQL() error = %v", err)
+	}
+
+	if _, err := db.ExecContext(ctx, "CREATE TABLE places (id serial PRIMARY KEY, name text)"); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+
+	if _, err := db.ExecContext(ctx, "INSERT INTO places (name) VALUES ('home')"); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	if err := pg.Truncate(ctx); err != nil {
+		t.Fatalf("Truncate() error = %v", err)
+	}
+
+	var places, srids int
+	if err := db.QueryRowContext(ctx,
+		"SELECT (SELECT count(*) FROM places), (SELECT count(*) FROM spatial_ref_sys)",
+	).Scan(&places, &srids); err != nil {
+		t.Fatalf("count rows: %v", err)
+	}
+
+	if places != 0 {
+		t.Errorf("places has %d rows after Truncate, want 0", places)
+	}
+
+	if srids == 0 {
+		t.Errorf("spatial_ref_sys is empty after Truncate")
+	}
+}
@@ -0,0 +1,649 @@
+package psql
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	errors "github.com/go-faster/errors"
+	pq "github.com/lib/pq"
+)
+
+// MigrationFormat is the layout of a migrations directory.
+type MigrationFormat int
+
+const (
+	// FormatPlain applies every .sql file in the order of the numeric name
+	// prefix, then of the name, and records the names in goat_migrations.
+	FormatPlain MigrationFormat = iota
+	// FormatGoose applies the Up sections of goose files (NNN_name.sql with
+	// "-- +goose Up") and records versions in goose_db_version.
+	FormatGoose
+	// FormatGolangMigrate applies NNN_name.up.sql files of golang-migrate
+	// and records the version in schema_migrations.
+	FormatGolangMigrate
+)
+
+const (
+	plainTable         = "goat_migrations"
+	gooseTable         = "goose_db_version"
+	golangMigrateTable = "schema_migrations"
+
+	gooseUp        = "-- +goose Up"
+	gooseDown      = "-- +goose Down"
+	gooseBegin     = "-- +goose StatementBegin"
+	gooseEnd       = "-- +goose StatementEnd"
+	gooseNoTx      = "-- +goose NO TRANSACTION"
+	upSuffix       = ".up.sql"
+	migrationExt   = ".sql"
+	versionDivider = "_"
+)
+
+// bookkeepingTables are written by migration tools and survive Truncate.
+var bookkeepingTables = []string{plainTable, gooseTable, golangMigrateTable}
+
+type (
+	migration struct {
+		file       string
+		version    string
+		statements []statement
+		noTx       bool
+	}
+
+	statement struct {
+		sql  string
+		line int // line of the file the statement starts at
+	}
+)
+
+// String returns the name of the format.
+func (f MigrationFormat) String() string {
+	switch f {
+	case FormatGoose:
+		return "goose"
+	case FormatGolangMigrate:
+		return "golang-migrate"
+	default:
+		return "plain"
+	}
+}
+
+// DetectMigrationFormat returns the layout of the migration files in fsys:
+// golang-migrate if any file ends with .up.sql, goose if any file has a
+// "-- +goose Up" annotation, plain otherwise.
+func DetectMigrationFormat(fsys fs.FS) (MigrationFormat, error) {
+	files, err := fs.Glob(fsys, "*"+migrationExt)
+	if err != nil {
+		return FormatPlain, err
+	}
+
+	format := FormatPlain
+
+	for _, file := range files {
+		if strings.HasSuffix(file, upSuffix) {
+			return FormatGolangMigrate, nil
+		}
+
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return FormatPlain, err
+		}
+
+		if strings.Contains(string(data), gooseUp) {
+			format = FormatGoose
+		}
+	}
+
+	return format, nil
+}
+
+// Migrate applies the migrations of fsys that are not applied yet, each file
+// in its own transaction, and sets MigrationVersion to the last applied
+// version. Applied migrations are recorded in the table of the format's own
+// tool, so the application's migrator sees them as done. A failing statement
+// is reported with its file, number and line.
+func (e *Env) Migrate(ctx context.Context, fsys fs.FS) error {
+	format, err := DetectMigrationFormat(fsys)
+	if err != nil {
+		return errors.Wrap(err, "detect migration format")
+	}
+
+	migrations, err := loadMigrations(fsys, format)
+	if err != nil {
+		return err
+	}
+
+	db, err := sql.Open("postgres", e.databaseURI(e.DBName))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	applied, err := appliedVersions(ctx, db, format)
+	if err != nil {
+		return errors.Wrapf(err, "read %s bookkeeping", format)
+	}
+
+	for _, m := range migrations {
+		if applied(m.version) {
+			e.MigrationVersion = m.version
+			continue
+		}
+
+		if err := applyMigration(ctx, db, format, m); err != nil {
+			return err
+		}
+
+		e.logger().DebugContext(ctx, "migration applied", slog.String("file", m.file))
+		e.MigrationVersion = m.version
+	}
+
+	return nil
+}
+
+func loadMigrations(fsys fs.FS, format MigrationFormat) ([]migration, error) {
+	files, err := fs.Glob(fsys, "*"+migrationExt)
+	if err != nil {
+		return nil, err
+	}
+
+	// an embed.FS keeps the directory name, the files are one level down
+	if len(files) == 0 {
+		return nil, errors.Errorf("no %s migrations at the root of the file system, pass the directory through fs.Sub", migrationExt)
+	}
+
+	var migrations []migration
+
+	for _, file := range files {
+		if format == FormatGolangMigrate && !strings.HasSuffix(file, upSuffix) {
+			continue
+		}
+
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+
+		m := migration{file: file, version: strings.TrimSuffix(path.Base(file), migrationExt)}
+
+		switch format {
+		case FormatPlain:
+			m.statements = splitStatements(string(data), 1)
+		case FormatGoose:
+			m.statements, m.noTx, err = parseGoose(string(data))
+		case FormatGolangMigrate:
+			m.statements = splitStatements(string(data), 1)
+		}
+
+		if err != nil {
+			return nil, errors.Wrapf(err, "migration %s", file)
+		}
+
+		if format != FormatPlain {
+			prefix, _, ok := strings.Cut(path.Base(file), versionDivider)
+
+			version, err := strconv.ParseInt(prefix, 10, 64)
+			if !ok || err != nil {
+				return nil, errors.Errorf("migration %s: file name must start with a numeric version", file)
+			}
+
+			// as stored by the tools, without leading zeros
+			m.version = strconv.FormatInt(version, 10)
+		}
+
+		migrations = append(migrations, m)
+	}
+
+	if format != FormatPlain {
+		slices.SortStableFunc(migrations, func(a, b migration) int {
+			va, _ := strconv.ParseInt(a.version, 10, 64) //nolint:errcheck // validated above
+			vb, _ := strconv.ParseInt(b.version, 10, 64) //nolint:errcheck // validated above
+
+			return cmp.Compare(va, vb)
+		})
+	} else {
+		slices.SortStableFunc(migrations, func(a, b migration) int {
+			return comparePlain(a.version, b.version)
+		})
+	}
+
+	return migrations, nil
+}
+
+// comparePlain orders plain migration names by their numeric prefix, so 2_b
+// runs before 10_a, and by name on equal prefixes. Names without a numeric
+// prefix go last, in name order.
+func comparePlain(a, b string) int {
+	na, aok := numericPrefix(a)
+	nb, bok := numericPrefix(b)
+
+	switch {
+	case aok && bok:
+		return cmp.Or(cmp.Compare(na, nb), strings.Compare(a, b))
+	case aok:
+		return -1
+	case bok:
+		return 1
+	default:
+		return strings.Compare(a, b)
+	}
+}
+
+func numericPrefix(name string) (uint64, bool) {
+	end := strings.IndexFunc(name, func(r rune) bool { return r < '0' || r > '9' })
+	if end < 0 {
+		end = len(name)
+	}
+
+	n, err := strconv.ParseUint(name[:end], 10, 64)
+
+	return n, err == nil
+}
+
+// appliedVersions creates the bookkeeping table if needed and returns a
+// function reporting whether a version is already applied.
+func appliedVersions(ctx context.Context, db *sql.DB, format MigrationFormat) (func(version string) bool, error) {
+	switch format {
+	case FormatGoose:
+		if _, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+gooseTable+` (
+			id serial PRIMARY KEY,
+			version_id bigint NOT NULL,
+			is_applied boolean NOT NULL,
+			tstamp timestamp DEFAULT now()
+		)`); err != nil {
+			return nil, err
+		}
+
+		if _, err := db.ExecContext(ctx, `INSERT INTO `+gooseTable+` (version_id, is_applied)
+			SELECT 0, true WHERE NOT EXISTS (SELECT 1 FROM `+gooseTable+`)`); err != nil {
+			return nil, err
+		}
+
+		versions, err := queryStrings(ctx, db, `SELECT version_id::text FROM `+gooseTable+` WHERE is_applied`)
+		if err != nil {
+			return nil, err
+		}
+
+		return func(version string) bool { return slices.Contains(versions, version) }, nil
+
+	case FormatGolangMigrate:
+		if _, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+golangMigrateTable+` (
+			version bigint NOT NULL PRIMARY KEY,
+			dirty boolean NOT NULL
+		)`); err != nil {
+			return nil, err
+		}
+
+		var (
+			current int64
+			dirty   bool
+		)
+
+		err := db.QueryRowContext(ctx, `SELECT version, dirty FROM `+golangMigrateTable+` LIMIT 1`).Scan(&current, &dirty)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+
+		// golang-migrate marks a migration dirty while it runs and leaves it so on failure
+		if dirty {
+			return nil, errors.Errorf("dirty migration %d, fix the database and the %s row", current, golangMigrateTable)
+		}
+
+		return func(version string) bool {
+			v, _ := strconv.ParseInt(version, 10, 64) //nolint:errcheck // validated in loadMigrations
+			return v <= current
+		}, nil
+
+	default:
+		if _, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+plainTable+` (
+			name text PRIMARY KEY,
+			applied_at timestamptz NOT NULL DEFAULT now()
+		)`); err != nil {
+			return nil, err
+		}
+
+		names, err := queryStrings(ctx, db, `SELECT name FROM `+plainTable)
+		if err != nil {
+			return nil, err
+		}
+
+		return func(version string) bool { return slices.Contains(names, version) }, nil
+	}
+}
+
+func applyMigration(ctx context.Context, db *sql.DB, format MigrationFormat, m migration) error {
+	var record string
+
+	switch format {
+	case FormatGoose:
+		record = `INSERT INTO ` + gooseTable + ` (version_id, is_applied) VALUES ($1, true)`
+	case FormatGolangMigrate:
+		record = `WITH deleted AS (DELETE FROM ` + golangMigrateTable + `)
+			INSERT INTO ` + golangMigrateTable + ` (version, dirty) VALUES ($1, false)`
+	default:
+		record = `INSERT INTO ` + plainTable + ` (name) VALUES ($1)`
+	}
+
+	type execer interface {
+		ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	}
+
+	var (
+		exec execer = db
+		tx   *sql.Tx
+	)
+
+	if !m.noTx {
+		var err error
+		if tx, err = db.BeginTx(ctx, nil); err != nil {
+			return err
+		}
+		defer tx.Rollback() //nolint:errcheck // no-op after commit
+
+		exec = tx
+	}
+
+	for i, stmt := range m.statements {
+		if _, err := exec.ExecContext(ctx, stmt.sql); err != nil {
+			return errors.Wrapf(err, "migration %s: statement %d at line %s", m.file, i+1, errorLine(stmt, err))
+		}
+	}
+
+	if _, err := exec.ExecContext(ctx, record, m.version); err != nil {
+		return errors.Wrapf(err, "migration %s: record version", m.file)
+	}
+
+	if tx != nil {
+		return tx.Commit()
+	}
+
+	return nil
+}
+
+// errorLine returns line:column of the error inside the statement when
+// PostgreSQL reports a position, the first line of the statement otherwise.
+func errorLine(stmt statement, err error) string {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) || pqErr.Position == "" {
+		return strconv.Itoa(stmt.line)
+	}
+
+	pos, convErr := strconv.Atoi(pqErr.Position)
+	if convErr != nil || pos < 1 {
+		return strconv.Itoa(stmt.line)
+	}
+
+	// Position counts characters from 1
+	runes := []rune(stmt.sql)
+	if pos > len(runes) {
+		pos = len(runes)
+	}
+
+	before := string(runes[:pos-1])
+	column := len([]rune(before[strings.LastIndex(before, "\n")+1:])) + 1
+
+	return fmt.Sprintf("%d:%d", stmt.line+strings.Count(before, "\n"), column)
+}
+
+// parseGoose returns the statements of the Up section of a goose migration.
+func parseGoose(script string) ([]statement, bool, error) {
+	var (
+		statements []statement
+		noTx       bool
+		inUp       bool
+		inBlock    bool
+		chunk      strings.Builder
+		chunkLine  int
+	)
+
+	flush := func() {
+		statements = append(statements, splitStatements(chunk.String(), chunkLine)...)
+		chunk.Reset()
+	}
+
+	lines := strings.SplitAfter(script, "\n")
+	for i, line := range lines {
+		lineNo := i + 1
+		annotation := strings.TrimSpace(line)
+
+		switch {
+		case strings.HasPrefix(annotation, gooseNoTx):
+			noTx = true
+
+			if inUp {
+				chunk.WriteString("\n") // keep the line numbers of the chunk
+			}
+
+			continue
+		case strings.HasPrefix(annotation, gooseUp):
+			inUp = true
+			chunkLine = lineNo + 1
+
+			continue
+		case strings.HasPrefix(annotation, gooseDown):
+			if inBlock {
+				return nil, false, errors.Errorf("line %d: %s without %s", lineNo, gooseBegin, gooseEnd)
+			}
+
+			flush()
+
+			return statements, noTx, nil
+		}
+
+		if !inUp {
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(annotation, gooseBegin):
+			flush()
+
+			inBlock = true
+			chunkLine = lineNo + 1
+		case strings.HasPrefix(annotation, gooseEnd):
+			if !inBlock {
+				return nil, false, errors.Errorf("line %d: %s without %s", lineNo, gooseEnd, gooseBegin)
+			}
+
+			if text := strings.TrimSpace(chunk.String()); text != "" {
+				statements = append(statements, statement{sql: text, line: chunkLine + leadingLines(chunk.String())})
+			}
+
+			chunk.Reset()
+
+			inBlock = false
+			chunkLine = lineNo + 1
+		default:
+			chunk.WriteString(line)
+		}
+	}
+
+	if !inUp {
+		return nil, false, errors.Errorf("no %s annotation", gooseUp)
+	}
+
+	if inBlock {
+		return nil, false, errors.Errorf("%s without %s", gooseBegin, gooseEnd)
+	}
+
+	flush()
+
+	return statements, noTx, nil
+}
+
+// splitStatements splits a script on semicolons outside of quotes, dollar
+// quoted strings and comments. firstLine is the line number of the script's
+// first line; statements consisting only of comments are dropped.
+func splitStatements(script string, firstLine int) []statement {
+	var (
+		statements []statement
+		runes      = []rune(script)
+		start      int
+		line       = firstLine
+		stmtLine   int
+		content    bool // the current statement has more than comments
+	)
+
+	// mark records the line of the first non-comment text of a statement
+	mark := func() {
+		if !content {
+			content, stmtLine = true, line
+		}
+	}
+
+	emit := func(end int) {
+		if content {
+			statements = append(statements, statement{sql: strings.TrimSpace(string(runes[start:end])), line: stmtLine})
+		}
+
+		start, content = end+1, false
+	}
+
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+
+		switch {
+		case r == '\n':
+			line++
+		case r == '-' && next(runes, i) == '-':
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+
+			if i < len(runes) {
+				line++
+			}
+		case r == '/' && next(runes, i) == '*':
+			depth := 0
+
+			for ; i < len(runes); i++ {
+				switch {
+				case runes[i] == '\n':
+					line++
+				case runes[i] == '/' && next(runes, i) == '*':
+					depth++
+					i++
+				case runes[i] == '*' && next(runes, i) == '/':
+					depth--
+					i++
+				}
+
+				if depth == 0 {
+					break
+				}
+			}
+		case r == '\'' || r == '"':
+			mark()
+			backslash := r == '\'' && i > 0 && (runes[i-1] == 'E' || runes[i-1] == 'e')
+
+			for i++; i < len(runes); i++ {
+				c := runes[i]
+				if c == '\n' {
+					line++
+				}
+
+				if backslash && c == '\\' {
+					i++
+					continue
+				}
+
+				if c == r {
+					if next(runes, i) == r {
+						i++
+						continue
+					}
+
+					break
+				}
+			}
+		case r == '$' && (i == 0 || !isIdentRune(runes[i-1])):
+			mark()
+
+			tag, ok := dollarTag(runes, i)
+			if !ok {
+				continue
+			}
+
+			end := strings.Index(string(runes[i+len(tag):]), tag)
+			if end < 0 {
+				i = len(runes)
+				continue
+			}
+
+			body := []rune(string(runes[i+len(tag):])[:end])
+			line += strings.Count(string(body), "\n")
+			i += len(tag) + len(body) + len(tag) - 1
+		case r == ';':
+			emit(i)
+		case !unicode.IsSpace(r):
+			mark()
+		}
+	}
+
+	emit(len(runes))
+
+	return statements
+}
+
+// dollarTag returns the $tag$ opening a dollar quoted string at runes[i].
+func dollarTag(runes []rune, i int) (string, bool) {
+	for j := i + 1; j < len(runes); j++ {
+		switch {
+		case runes[j] == '$':
+			return string(runes[i : j+1]), true
+		case unicode.IsDigit(runes[j]) && j == i+1:
+			return "", false // positional parameter
+		case !isIdentRune(runes[j]):
+			return "", false
+		}
+	}
+
+	return "", false
+}
+
+func isIdentRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func next(runes []rune, i int) rune {
+	if i+1 < len(runes) {
+		return runes[i+1]
+	}
+
+	return 0
+}
+
+// leadingLines counts the blank lines before the first statement text.
+func leadingLines(text string) int {
+	trimmed := strings.TrimLeftFunc(text, unicode.IsSpace)
+	return strings.Count(text[:len(text)-len(trimmed)], "\n")
+}
+
+func queryStrings(ctx context.Context, db *sql.DB, query string, args ...any) ([]string, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []string
+
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+
+		values = append(values, value)
+	}
+
+	return values, rows.Err()
+}
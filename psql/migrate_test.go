@@ -0,0 +1,213 @@
+package psql
+
+import (
+	"context"
+	"io/fs"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	pq "github.com/lib/pq"
+	testcontainers "github.com/testcontainers/testcontainers-go"
+)
+
+func TestSplitStatements(t *testing.T) {
+	script := `-- orders
+CREATE TABLE orders (id int, note text DEFAULT 'a;b');
+
+/* block; /* nested; */ comment */
+CREATE FUNCTION f() RETURNS int AS $body$
+BEGIN
+  RETURN 1;
+END
+$body$ LANGUAGE plpgsql;
+INSERT INTO orders VALUES ($1, E'it\'s;');
+-- trailing comment;
+`
+
+	got := splitStatements(script, 1)
+
+	lines := make([]int, 0, len(got))
+	for _, stmt := range got {
+		lines = append(lines, stmt.line)
+	}
+
+	if !slices.Equal(lines, []int{2, 5, 10}) {
+		t.Fatalf("statement lines = %v, want [2 5 10], statements: %q", lines, got)
+	}
+
+	if got[2].sql != `INSERT INTO orders VALUES ($1, E'it\'s;')` {
+		t.Errorf("third statement = %q", got[2].sql)
+	}
+}
+
+func TestParseGoose(t *testing.T) {
+	script := `-- +goose Up
+-- +goose NO TRANSACTION
+CREATE TABLE a (id int);
+-- +goose StatementBegin
+CREATE FUNCTION f() RETURNS int AS 'SELECT 1; SELECT 2' LANGUAGE sql;
+-- +goose StatementEnd
+CREATE INDEX CONCURRENTLY a_id ON a (id);
+
+-- +goose Down
+DROP TABLE a;
+`
+
+	got, noTx, err := parseGoose(script)
+	if err != nil {
+		t.Fatalf("parseGoose() error = %v", err)
+	}
+
+	if !noTx {
+		t.Errorf("NO TRANSACTION annotation is ignored")
+	}
+
+	lines := make([]int, 0, len(got))
+	for _, stmt := range got {
+		lines = append(lines, stmt.line)
+	}
+
+	if !slices.Equal(lines, []int{3, 5, 7}) {
+		t.Errorf("statement lines = %v, want [3 5 7], statements: %q", lines, got)
+	}
+
+	if _, _, err := parseGoose("CREATE TABLE a (id int);"); err == nil {
+		t.Errorf("parseGoose() without Up annotation expected error")
+	}
+}
+
+func TestLoadMigrations(t *testing.T) {
+	tests := []struct {
+		name     string
+		fsys     fstest.MapFS
+		format   MigrationFormat
+		versions []string
+	}{
+		{
+			name: "plain",
+			fsys: fstest.MapFS{
+				"002_items.sql":  {Data: []byte("CREATE TABLE items (id int);")},
+				"001_orders.sql": {Data: []byte("CREATE TABLE orders (id int);")},
+				"README.md":      {Data: []byte("not a migration")},
+			},
+			format:   FormatPlain,
+			versions: []string{"001_orders", "002_items"},
+		},
+		{
+			name: "plain numeric order",
+			fsys: fstest.MapFS{
+				"10_x.sql":   {Data: []byte("CREATE TABLE x (id int);")},
+				"2_y.sql":    {Data: []byte("CREATE TABLE y (id int);")},
+				"seed.sql":   {Data: []byte("INSERT INTO y VALUES (1);")},
+				"2_a.sql":    {Data: []byte("CREATE TABLE a (id int);")},
+				"0001_z.sql": {Data: []byte("CREATE TABLE z (id int);")},
+			},
+			format:   FormatPlain,
+			versions: []string{"0001_z", "2_a", "2_y", "10_x", "seed"},
+		},
+		{
+			name: "goose",
+			fsys: fstest.MapFS{
+				"10_items.sql": {Data: []byte("-- +goose Up\nCREATE TABLE items (id int);")},
+				"9_orders.sql": {Data: []byte("-- +goose Up\nCREATE TABLE orders (id int);")},
+			},
+			format:   FormatGoose,
+			versions: []string{"9", "10"},
+		},
+		{
+			name: "golang-migrate",
+			fsys: fstest.MapFS{
+				"000002_items.up.sql":    {Data: []byte("CREATE TABLE items (id int);")},
+				"000002_items.down.sql":  {Data: []byte("DROP TABLE items;")},
+				"000001_orders.up.sql":   {Data: []byte("CREATE TABLE orders (id int);")},
+				"000001_orders.down.sql": {Data: []byte("DROP TABLE orders;")},
+			},
+			format:   FormatGolangMigrate,
+			versions: []string{"1", "2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			format, err := DetectMigrationFormat(tt.fsys)
+			if err != nil || format != tt.format {
+				t.Fatalf("DetectMigrationFormat() = %v, %v, want %v", format, err, tt.format)
+			}
+
+			migrations, err := loadMigrations(tt.fsys, format)
+			if err != nil {
+				t.Fatalf("loadMigrations() error = %v", err)
+			}
+
+			versions := make([]string, 0, len(migrations))
+			for _, m := range migrations {
+				versions = append(versions, m.version)
+			}
+
+			if !slices.Equal(versions, tt.versions) {
+				t.Errorf("versions = %v, want %v", versions, tt.versions)
+			}
+		})
+	}
+}
+
+func TestLoadMigrationsNeedsRoot(t *testing.T) {
+	// an embed.FS passed without fs.Sub
+	fsys := fstest.MapFS{"migrations/1_users.sql": {Data: []byte("CREATE TABLE users (id int);")}}
+
+	if _, err := loadMigrations(fsys, FormatPlain); err == nil || !strings.Contains(err.Error(), "fs.Sub") {
+		t.Fatalf("loadMigrations() error = %v, want a hint about fs.Sub", err)
+	}
+
+	sub, err := fs.Sub(fsys, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if migrations, err := loadMigrations(sub, FormatPlain); err != nil || len(migrations) != 1 {
+		t.Errorf("loadMigrations(fs.Sub) = %d migrations, %v, want 1", len(migrations), err)
+	}
+}
+
+func TestErrorLine(t *testing.T) {
+	stmt := statement{sql: "CREATE TABLE a (\n  id int,\n  bad typo\n)", line: 7}
+
+	if got := errorLine(stmt, &pq.Error{Position: "31"}); got != "9:4" {
+		t.Errorf("errorLine() = %q, want 9:4", got)
+	}
+
+	if got := errorLine(stmt, &pq.Error{}); got != "7" {
+		t.Errorf("errorLine() without position = %q, want 7", got)
+	}
+}
+
+func TestMigrateRejectsDirtyVersion(t *testing.T) {
+	testcontainers.SkipIfProviderIsNotHealthy(t)
+
+	ctx := context.Background()
+
+	pg, err := Run(ctx)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	t.Cleanup(func() { _ = pg.Terminate(ctx) })
+
+	db, err := pg.SQL()
+	if err != nil {
+		t.Fatalf("SQL() error = %v", err)
+	}
+
+	if _, err := db.ExecContext(ctx, `CREATE TABLE schema_migrations (version bigint NOT NULL PRIMARY KEY, dirty boolean NOT NULL);
+		INSERT INTO schema_migrations VALUES (1, true)`); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+
+	fsys := fstest.MapFS{"000001_users.up.sql": {Data: []byte("CREATE TABLE users (id int);")}}
+
+	if err := pg.Migrate(ctx, fsys); err == nil || !strings.Contains(err.Error(), "dirty migration 1") {
+		t.Errorf("Migrate() error = %v, want dirty migration 1", err)
+	}
+}
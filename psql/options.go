@@ -0,0 +1,233 @@
+package psql
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"slices"
+
+	errors "github.com/go-faster/errors"
+	testcontainers "github.com/testcontainers/testcontainers-go"
+)
+
+const (
+	// dataDir is PGDATA of the fast profile, set explicitly as the default
+	// location differs between image versions.
+	dataDir      = "/var/lib/postgresql/data"
+	pgDataEnvKey = "PGDATA"
+)
+
+// fastSettings trade durability for speed; a crashed test database is
+// simply recreated.
+var fastSettings = map[string]string{
+	"fsync":              "off",
+	"synchronous_commit": "off",
+	"full_page_writes":   "off",
+}
+
+type (
+	// Option configures PostgreSQL specific behavior of Run. Options are passed
+	// to Run along with regular customizers and do not change the request.
+	Option func(*options)
+
+	options struct {
+		migrations fs.FS
+		poolSize   int
+		params     map[string]string
+		extensions []string
+		databases  []string
+		roles      []Role
+		recorder   *recorder
+		pgbouncer  *pgbouncerOptions
+	}
+)
+
+// Customize implements testcontainers.ContainerCustomizer.
+func (o Option) Customize(*testcontainers.GenericContainerRequest) error {
+	return nil
+}
+
+// HashKey implements common.HashKeyer, so WithReuse and common.Share tell
+// apart servers set up differently.
+func (o Option) HashKey() (string, error) {
+	var opts options
+	o(&opts)
+
+	return opts.hashKey()
+}
+
+// WithMigrations applies the migrations of fsys after the database is ready,
+// see Migrate for the supported layouts.
+func WithMigrations(fsys fs.FS) Option {
+	return func(o *options) {
+		o.migrations = fsys
+	}
+}
+
+// WithMigrationsDir applies the migrations of a host directory, see WithMigrations.
+func WithMigrationsDir(dir string) Option {
+	return WithMigrations(os.DirFS(dir))
+}
+
+// WithPoolSize limits the connections of both Env.SQL and Env.Pool.
+func WithPoolSize(size int) Option {
+	return func(o *options) {
+		o.poolSize = size
+	}
+}
+
+// WithConnParams adds connection parameters, e.g. application_name or
+// connect_timeout, to the DSN, URI and connections of the Env. A sslmode
+// parameter replaces the default sslmode=disable.
+func WithConnParams(params map[string]string) Option {
+	return func(o *options) {
+		if o.params == nil {
+			o.params = map[string]string{}
+		}
+
+		maps.Copy(o.params, params)
+	}
+}
+
+// WithDatabases creates more databases, owned by the Env user, next to the
+// Env database. Connect to them with DSNFor and SQLFor.
+func WithDatabases(names ...string) Option {
+	return func(o *options) {
+		o.databases = append(o.databases, names...)
+	}
+}
+
+// WithRole creates a login role with its schema and grants, see Role.
+// Connect as the role with DSNFor and SQLFor.
+func WithRole(role Role) Option {
+	return func(o *options) {
+		o.roles = append(o.roles, role)
+	}
+}
+
+// WithSettings passes server settings as "postgres -c key=value" arguments,
+// in key order. Settings given later override earlier ones.
+func WithSettings(settings map[string]string) testcontainers.CustomizeRequestOption {
+	return func(req *testcontainers.GenericContainerRequest) error {
+		if len(req.Cmd) == 0 {
+			req.Cmd = []string{"postgres"}
+		}
+
+		for _, key := range slices.Sorted(maps.Keys(settings)) {
+			req.Cmd = append(req.Cmd, "-c", key+"="+settings[key])
+		}
+
+		return nil
+	}
+}
+
+// WithFastProfile keeps the data directory on tmpfs and turns off fsync,
+// synchronous_commit and full_page_writes. Data does not survive a container
+// restart, which tests do not need, and write heavy suites run much faster.
+func WithFastProfile() testcontainers.CustomizeRequestOption {
+	return func(req *testcontainers.GenericContainerRequest) error {
+		customizers := []testcontainers.CustomizeRequestOption{
+			testcontainers.WithTmpfs(map[string]string{dataDir: "rw"}),
+			testcontainers.WithEnv(map[string]string{pgDataEnvKey: dataDir}),
+			WithSettings(fastSettings),
+		}
+
+		for _, customize := range customizers {
+			if err := customize(req); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+// collectOptions applies the Options found among the customizers.
+func collectOptions(opts []testcontainers.ContainerCustomizer) *options {
+	o := &options{}
+
+	for _, opt := range opts {
+		switch opt := opt.(type) {
+		case Option:
+			opt(o)
+		case extensionsOption:
+			o.extensions = append(o.extensions, opt...)
+		case captureOption:
+			o.recorder = opt.rec
+		}
+	}
+
+	return o
+}
+
+// hashKey describes what the options create on the server. Connection
+// settings such as the pool size are left out, they do not change it.
+func (o *options) hashKey() (string, error) {
+	key := struct {
+		Migrations string   `json:",omitempty"`
+		Extensions []string `json:",omitempty"`
+		Databases  []string `json:",omitempty"`
+		Roles      []Role   `json:",omitempty"`
+		PgBouncer  *struct {
+			Mode     PoolMode
+			Settings map[string]string
+		} `json:",omitempty"`
+	}{
+		Extensions: o.extensions,
+		Databases:  o.databases,
+		Roles:      o.roles,
+	}
+
+	if o.migrations != nil {
+		sum, err := hashFS(o.migrations)
+		if err != nil {
+			return "", errors.Wrap(err, "hash migrations")
+		}
+
+		key.Migrations = sum
+	}
+
+	if o.pgbouncer != nil {
+		key.PgBouncer = &struct {
+			Mode     PoolMode
+			Settings map[string]string
+		}{o.pgbouncer.mode, o.pgbouncer.settings}
+	}
+
+	data, err := json.Marshal(key)
+	if err != nil || string(data) == "{}" {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+// hashFS returns a hash of the names and contents of the files of fsys.
+func hashFS(fsys fs.FS) (string, error) {
+	h := sha256.New()
+
+	err := fs.WalkDir(fsys, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+
+		sum := sha256.Sum256(data)
+		_, _ = fmt.Fprintf(h, "%s %x\n", name, sum) //nolint:errcheck // hash writes do not fail
+
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
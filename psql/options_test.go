@@ -0,0 +1,94 @@
+package psql
+
+import (
+	"slices"
+	"testing"
+	"testing/fstest"
+
+	testcontainers "github.com/testcontainers/testcontainers-go"
+
+	common "github.com/Educentr/goat-services/common"
+)
+
+func TestWithSettings(t *testing.T) {
+	req := testcontainers.GenericContainerRequest{}
+	req.Cmd = []string{"postgres", "-c", "fsync=off"}
+
+	if err := WithSettings(map[string]string{"work_mem": "64MB", "max_connections": "300"})(&req); err != nil {
+		t.Fatalf("WithSettings() error = %v", err)
+	}
+
+	want := []string{"postgres", "-c", "fsync=off", "-c", "max_connections=300", "-c", "work_mem=64MB"}
+	if !slices.Equal(req.Cmd, want) {
+		t.Errorf("Cmd = %v, want %v", req.Cmd, want)
+	}
+
+	empty := testcontainers.GenericContainerRequest{}
+	if err := WithSettings(map[string]string{"fsync": "off"})(&empty); err != nil {
+		t.Fatalf("WithSettings() error = %v", err)
+	}
+
+	if !slices.Equal(empty.Cmd, []string{"postgres", "-c", "fsync=off"}) {
+		t.Errorf("Cmd without module defaults = %v", empty.Cmd)
+	}
+}
+
+func TestWithFastProfile(t *testing.T) {
+	req := testcontainers.GenericContainerRequest{}
+
+	if err := WithFastProfile()(&req); err != nil {
+		t.Fatalf("WithFastProfile() error = %v", err)
+	}
+
+	if _, ok := req.Tmpfs[dataDir]; !ok || req.Env[pgDataEnvKey] != dataDir {
+		t.Errorf("data directory is not on tmpfs: Tmpfs = %v, Env = %v", req.Tmpfs, req.Env)
+	}
+
+	for _, setting := range []string{"fsync=off", "synchronous_commit=off", "full_page_writes=off"} {
+		if !slices.Contains(req.Cmd, setting) {
+			t.Errorf("Cmd %v has no %s", req.Cmd, setting)
+		}
+	}
+}
+
+func TestOptionsChangeRequestHash(t *testing.T) {
+	migrations := fstest.MapFS{"1_users.sql": {Data: []byte("CREATE TABLE users (id int);")}}
+
+	sets := map[string][]testcontainers.ContainerCustomizer{
+		"plain":      nil,
+		"extensions": {WithExtensions("vector")},
+		"migrations": {WithMigrations(migrations)},
+		"databases":  {WithDatabases("reports")},
+		"role":       {WithRole(Role{Name: "reader"})},
+		"pgbouncer":  {WithPgBouncer(PoolSession)},
+	}
+
+	seen := map[string]string{}
+
+	for name, opts := range sets {
+		key, err := common.RequestHash(kind, opts...)
+		if err != nil {
+			t.Fatalf("RequestHash(%s) error = %v", name, err)
+		}
+
+		if other, ok := seen[key]; ok {
+			t.Errorf("options %s and %s have the same hash %s", name, other, key)
+		}
+
+		seen[key] = name
+	}
+
+	changed := fstest.MapFS{"1_users.sql": {Data: []byte("CREATE TABLE users (id bigint);")}}
+
+	first, _ := common.RequestHash(kind, WithMigrations(migrations), WithPoolSize(2))
+	second, _ := common.RequestHash(kind, WithMigrations(migrations))
+	third, _ := common.RequestHash(kind, WithMigrations(changed))
+
+	if first != second {
+		t.Errorf("WithPoolSize changes the hash, the server is the same")
+	}
+
+	if first == third {
+		t.Errorf("changed migrations keep the hash")
+	}
+}
@@ -0,0 +1,209 @@
+package psql
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/docker/go-connections/nat"
+	testcontainers "github.com/testcontainers/testcontainers-go"
+
+	common "github.com/Educentr/goat-services/common"
+)
+
+// PoolMode is the PgBouncer pool_mode.
+type PoolMode string
+
+const (
+	// PoolSession assigns a server connection for the life of the client connection.
+	PoolSession PoolMode = "session"
+	// PoolTransaction assigns a server connection per transaction, like most
+	// production setups; session state such as prepared statements does not
+	// survive between transactions.
+	PoolTransaction PoolMode = "transaction"
+	// PoolStatement assigns a server connection per statement.
+	PoolStatement PoolMode = "statement"
+)
+
+const (
+	pgbouncerKind  = "pgbouncer"
+	pgbouncerAlias = "pgbouncer"
+
+	pgbouncerPort nat.Port = "6432/tcp"
+
+	pgbouncerConfig   = "/etc/pgbouncer/pgbouncer.ini"
+	pgbouncerUserlist = "/etc/pgbouncer/userlist.txt"
+)
+
+type (
+	pgbouncerOptions struct {
+		mode     PoolMode
+		settings map[string]string
+	}
+)
+
+// WithPgBouncer starts a PgBouncer sidecar with the pool mode in front of
+// the database, on the network of the container or on a new one, and fills
+// the Pooler* fields of the Env. The users of the Env and of WithRole can
+// connect through it. Together with common.WithReuse the container must be
+// given a network with common.WithNetworkAlias.
+func WithPgBouncer(mode PoolMode) Option {
+	return func(o *options) {
+		o.pgbouncer = o.pgbouncerOptions()
+		o.pgbouncer.mode = mode
+	}
+}
+
+// WithPgBouncerSettings adds settings to the [pgbouncer] section, e.g.
+// max_prepared_statements or default_pool_size. It implies WithPgBouncer in
+// transaction mode unless another mode is set.
+func WithPgBouncerSettings(settings map[string]string) Option {
+	return func(o *options) {
+		o.pgbouncer = o.pgbouncerOptions()
+		maps.Copy(o.pgbouncer.settings, settings)
+	}
+}
+
+func (o *options) pgbouncerOptions() *pgbouncerOptions {
+	if o.pgbouncer == nil {
+		return &pgbouncerOptions{mode: PoolTransaction, settings: map[string]string{}}
+	}
+
+	return o.pgbouncer
+}
+
+// PoolerDSN returns the connection parameters through PgBouncer, see WithPgBouncer.
+func (e *Env) PoolerDSN() DSN {
+	return e.dsn(e.PoolerHost, e.PoolerPort, e.DBName)
+}
+
+// startPgBouncer starts the sidecar on the network where the database is
+// reachable as host.
+func (e *Env) startPgBouncer(ctx context.Context, networkName, host string, cfg *pgbouncerOptions) error {
+	image, err := common.ResolveImage(pgbouncerKind)
+	if err != nil {
+		return err
+	}
+
+	req := testcontainers.GenericContainerRequest{
+		Started: true,
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image:             image,
+			ImageSubstitutors: common.ImageSubstitutors(),
+			Cmd:               []string{"pgbouncer", pgbouncerConfig},
+			ExposedPorts:      []string{string(pgbouncerPort)},
+			Files: []testcontainers.ContainerFile{
+				{
+					Reader:            strings.NewReader(pgbouncerINI(host, e.DBUser, cfg)),
+					ContainerFilePath: pgbouncerConfig,
+					FileMode:          0o644,
+				},
+				{
+					Reader:            strings.NewReader(pgbouncerUsers(e.users())),
+					ContainerFilePath: pgbouncerUserlist,
+					FileMode:          0o644,
+				},
+			},
+			// host and port are filled by the strategy
+			WaitingFor: waitForSQL(pgbouncerPort, e.dsn("", "", e.DBName)),
+		},
+	}
+
+	settings, err := common.Customize(pgbouncerKind, &req, common.WithNetworkAlias(networkName, pgbouncerAlias))
+	if err != nil {
+		return err
+	}
+
+	bouncer, err := common.Start(ctx, settings,
+		func(ctx context.Context) (testcontainers.Container, error) {
+			return testcontainers.GenericContainer(ctx, req)
+		},
+		func(ctx context.Context, c testcontainers.Container) error {
+			port, err := c.MappedPort(ctx, pgbouncerPort)
+			if err != nil {
+				return err
+			}
+
+			host, err := c.Host(ctx)
+			if err != nil {
+				return err
+			}
+
+			e.PoolerHost, e.PoolerPort = host, port.Port()
+
+			return nil
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	e.bouncer = bouncer
+	e.PoolerURI = e.PoolerDSN().URL()
+
+	return nil
+}
+
+// users returns the passwords of the Env user and the WithRole roles.
+func (e *Env) users() map[string]string {
+	users := map[string]string{e.DBUser: e.DBPass}
+	for _, r := range e.roles {
+		users[r.Name] = r.Password
+	}
+
+	return users
+}
+
+// pgbouncerINI renders pgbouncer.ini routing every database to host.
+func pgbouncerINI(host, admin string, cfg *pgbouncerOptions) string {
+	settings := map[string]string{
+		"listen_addr":               "*",
+		"listen_port":               pgbouncerPort.Port(),
+		"auth_type":                 "scram-sha-256",
+		"auth_file":                 pgbouncerUserlist,
+		"admin_users":               admin,
+		"pool_mode":                 string(cfg.mode),
+		"max_client_conn":           "1000",
+		"ignore_startup_parameters": "extra_float_digits,options",
+	}
+	maps.Copy(settings, cfg.settings)
+
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "[databases]\n* = host=%s port=%s\n\n[pgbouncer]\n", host, postgresPort.Port())
+
+	for _, key := range slices.Sorted(maps.Keys(settings)) {
+		fmt.Fprintf(&b, "%s = %s\n", key, settings[key])
+	}
+
+	return b.String()
+}
+
+// pgbouncerUsers renders the auth_file with plain text passwords, which
+// PgBouncer also needs to log in to the server with SCRAM.
+func pgbouncerUsers(users map[string]string) string {
+	quote := func(s string) string {
+		return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+	}
+
+	var b strings.Builder
+
+	for _, name := range slices.Sorted(maps.Keys(users)) {
+		b.WriteString(quote(name) + " " + quote(users[name]) + "\n")
+	}
+
+	return b.String()
+}
+
+// aliasNetwork returns the first network on which the request has an alias.
+func aliasNetwork(req *testcontainers.GenericContainerRequest) string {
+	for _, nw := range req.Networks {
+		if len(req.NetworkAliases[nw]) > 0 {
+			return nw
+		}
+	}
+
+	return ""
+}
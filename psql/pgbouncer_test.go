@@ -0,0 +1,75 @@
+package psql
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	testcontainers "github.com/testcontainers/testcontainers-go"
+
+	common "github.com/Educentr/goat-services/common"
+)
+
+func TestPgBouncerOptions(t *testing.T) {
+	o := collectOptions([]testcontainers.ContainerCustomizer{
+		WithPgBouncerSettings(map[string]string{"max_prepared_statements": "100"}),
+	})
+
+	if o.pgbouncer == nil || o.pgbouncer.mode != PoolTransaction {
+		t.Fatalf("WithPgBouncerSettings() does not imply transaction mode: %+v", o.pgbouncer)
+	}
+
+	o = collectOptions([]testcontainers.ContainerCustomizer{
+		WithPgBouncerSettings(map[string]string{"default_pool_size": "5"}),
+		WithPgBouncer(PoolSession),
+	})
+
+	if o.pgbouncer.mode != PoolSession || o.pgbouncer.settings["default_pool_size"] != "5" {
+		t.Errorf("options = %+v", o.pgbouncer)
+	}
+}
+
+func TestPgBouncerINI(t *testing.T) {
+	ini := pgbouncerINI("postgres", "app", &pgbouncerOptions{
+		mode:     PoolStatement,
+		settings: map[string]string{"max_client_conn": "50"},
+	})
+
+	for _, line := range []string{
+		"[databases]\n* = host=postgres port=5432\n",
+		"pool_mode = statement\n",
+		"listen_port = 6432\n",
+		"admin_users = app\n",
+		"max_client_conn = 50\n",
+	} {
+		if !strings.Contains(ini, line) {
+			t.Errorf("pgbouncer.ini has no %q:\n%s", line, ini)
+		}
+	}
+}
+
+func TestPgBouncerUsers(t *testing.T) {
+	env := &Env{DBUser: "app", DBPass: `pa"ss`, roles: []Role{{Name: "reader", Password: "secret"}}}
+
+	want := "\"app\" \"pa\"\"ss\"\n\"reader\" \"secret\"\n"
+	if got := pgbouncerUsers(env.users()); got != want {
+		t.Errorf("userlist = %q, want %q", got, want)
+	}
+}
+
+func TestAliasNetwork(t *testing.T) {
+	req := testcontainers.GenericContainerRequest{}
+	req.Networks = []string{"bridge", "stack"}
+	req.NetworkAliases = map[string][]string{"stack": {"db"}}
+
+	if got := aliasNetwork(&req); got != "stack" {
+		t.Errorf("aliasNetwork() = %q, want stack", got)
+	}
+}
+
+func TestPgBouncerRejectsReuseWithoutNetwork(t *testing.T) {
+	_, err := Run(context.Background(), common.WithReuse(), WithPgBouncer(PoolTransaction))
+	if err == nil || !strings.Contains(err.Error(), "WithReuse") {
+		t.Fatalf("Run() error = %v, want WithReuse rejected", err)
+	}
+}
@@ -3,16 +3,21 @@ package psql
 import (
 	"context"
 	"database/sql"
-	"fmt"
+	"io/fs"
+	"log/slog"
+	"math"
 	"net"
+	"slices"
 	"sync"
 	"time"
 
 	"github.com/docker/go-connections/nat"
-	_ "github.com/lib/pq" // PostgreSQL driver
+	errors "github.com/go-faster/errors"
+	"github.com/jackc/pgx/v5/pgxpool"
+	pq "github.com/lib/pq"
 	testcontainers "github.com/testcontainers/testcontainers-go"
 	postgres "github.com/testcontainers/testcontainers-go/modules/postgres"
-	"github.com/testcontainers/testcontainers-go/wait"
+	"github.com/testcontainers/testcontainers-go/network"
 
 	common "github.com/Educentr/goat-services/common"
 )
@@ -27,6 +32,10 @@ const (
 	dbNameEnvKey   = "POSTGRES_DB"
 
 	startTimeout = 60 * time.Second
+
+	postgresPort nat.Port = "5432/tcp"
+
+	kind = "postgres"
 )
 
 type (
@@ -39,13 +48,49 @@ type (
 		DBPort string
 		DBHost string
 
-		db   *sql.DB
-		dbMu sync.Mutex
+		// Internal* fields are set when the container has a network alias,
+		// see common.WithNetworkAlias.
+		InternalURI    string
+		InternalDBHost string
+		InternalDBPort string
+
+		// MigrationVersion is the last migration applied by WithMigrations or Migrate.
+		MigrationVersion string
+
+		// Pooler* fields are set by WithPgBouncer.
+		PoolerURI  string
+		PoolerHost string
+		PoolerPort string
+
+		name       string
+		image      string   // as requested, before substitution
+		cmd        []string // server command with the settings, copied to replicas
+		log        *slog.Logger
+		poolSize   int
+		params     map[string]string
+		extensions []string
+		migrations fs.FS
+		databases  []string
+		roles      []Role
+		rec        *recorder
+
+		bouncer       testcontainers.Container
+		removeNetwork func(ctx context.Context) error
+		network       string // created for the sidecar
+
+		db    *sql.DB
+		pool  *pgxpool.Pool
+		conns map[connKey]*sql.DB
+		dbMu  sync.Mutex // guards db, pool and conns
+
+		tmpl   *template
+		tmplMu sync.Mutex
 	}
 )
 
 var (
-	defaultImage = common.DockerProxy("postgres:15.3-alpine3.18")
+	_ common.Service  = (*Env)(nil)
+	_ common.Resetter = (*Env)(nil)
 )
 
 // SQL returns a cached database connection. The connection is created on first call
@@ -62,10 +107,237 @@ func (e *Env) SQL() (*sql.DB, error) {
 	if err != nil {
 		return nil, err
 	}
+	if e.poolSize > 0 {
+		db.SetMaxOpenConns(e.poolSize)
+	}
 	e.db = db
 	return e.db, nil
 }
 
+// Pool returns a cached pgx connection pool, created on first call with the
+// size set by WithPoolSize. The pool is closed by Terminate.
+func (e *Env) Pool(ctx context.Context) (*pgxpool.Pool, error) {
+	e.dbMu.Lock()
+	defer e.dbMu.Unlock()
+
+	if e.pool != nil {
+		return e.pool, nil
+	}
+
+	config, err := pgxpool.ParseConfig(e.databaseURI(e.DBName))
+	if err != nil {
+		return nil, err
+	}
+
+	if e.poolSize > 0 {
+		config.MaxConns = int32(min(e.poolSize, math.MaxInt32)) //nolint:gosec // G115: bounded above
+	}
+
+	pool, err := pgxpool.NewWithConfig(ctx, config)
+	if err != nil {
+		return nil, errors.Wrap(err, "create pgx pool")
+	}
+
+	e.pool = pool
+
+	return pool, nil
+}
+
+// Terminate closes the clone pool of MarkTemplate and the cached SQL and
+// Pool connections, then stops and removes the PgBouncer sidecar, the
+// container and the network created for the sidecar. An Env returned by Load
+// has no container, for it only the connections are closed.
+func (e *Env) Terminate(ctx context.Context, opts ...testcontainers.TerminateOption) error {
+	errs := []error{e.CloseTemplate(ctx), e.closeSQL()}
+
+	if e.bouncer != nil {
+		if err := e.bouncer.Terminate(ctx, opts...); err != nil {
+			errs = append(errs, errors.Wrap(err, "terminate pgbouncer"))
+		}
+	}
+
+	if e.Container != nil {
+		errs = append(errs, e.Container.Terminate(ctx, opts...))
+	}
+
+	if e.removeNetwork != nil {
+		if err := e.removeNetwork(ctx); err != nil {
+			errs = append(errs, errors.Wrap(err, "remove network"))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Sidecars implements common.Sidecars, it returns the PgBouncer sidecar and
+// the network created for it.
+func (e *Env) Sidecars() (containers, networks []string) {
+	if e.bouncer != nil {
+		containers = append(containers, e.bouncer.GetContainerID())
+	}
+
+	if e.network != "" {
+		networks = append(networks, e.network)
+	}
+
+	return containers, networks
+}
+
+// closeSQL closes the cached connections of SQL, Pool and SQLFor, the next
+// calls open new ones.
+func (e *Env) closeSQL() error {
+	e.dbMu.Lock()
+	defer e.dbMu.Unlock()
+
+	if e.pool != nil {
+		e.pool.Close()
+		e.pool = nil
+	}
+
+	var errs []error
+
+	for key, db := range e.conns {
+		errs = append(errs, db.Close())
+		delete(e.conns, key)
+	}
+
+	if e.db != nil {
+		errs = append(errs, e.db.Close())
+		e.db = nil
+	}
+
+	return errors.Join(errs...)
+}
+
+func (e *Env) logger() *slog.Logger {
+	if e.log == nil {
+		return common.Logger()
+	}
+
+	return e.log
+}
+
+// ServiceName implements common.Service.
+func (e *Env) ServiceName() string {
+	return e.name
+}
+
+// Kind implements common.Service.
+func (e *Env) Kind() string {
+	return kind
+}
+
+// Endpoints implements common.Service.
+func (e *Env) Endpoints() map[string]string {
+	endpoints := map[string]string{
+		"postgres": net.JoinHostPort(e.DBHost, e.DBPort),
+	}
+	common.AddInternalEndpoint(endpoints, "postgres", common.InternalAddress(e.InternalDBHost, e.InternalDBPort))
+
+	return endpoints
+}
+
+// HealthCheck implements common.Service.
+func (e *Env) HealthCheck(ctx context.Context) error {
+	db, err := e.SQL()
+	if err != nil {
+		return err
+	}
+
+	return db.PingContext(ctx)
+}
+
+// Reset implements common.Resetter. It drops every schema of the database
+// except the system ones, recreates an empty public schema and sets the
+// database up again as Run does: the extensions of WithExtensions, the role
+// schemas of WithRole, the migrations of WithMigrations and the role grants.
+func (e *Env) Reset(ctx context.Context) error {
+	db, err := e.SQL()
+	if err != nil {
+		return err
+	}
+
+	rows, err := db.QueryContext(ctx,
+		`SELECT nspname FROM pg_namespace WHERE nspname NOT LIKE 'pg\_%' AND nspname <> 'information_schema'`)
+	if err != nil {
+		return err
+	}
+
+	var schemas []string
+
+	for rows.Next() {
+		var schema string
+		if err := rows.Scan(&schema); err != nil {
+			return errors.Join(err, rows.Close())
+		}
+
+		schemas = append(schemas, schema)
+	}
+
+	if err := errors.Join(rows.Err(), rows.Close()); err != nil {
+		return err
+	}
+
+	for _, schema := range schemas {
+		if _, err := db.ExecContext(ctx, "DROP SCHEMA "+pq.QuoteIdentifier(schema)+" CASCADE"); err != nil {
+			return errors.Wrapf(err, "drop schema %s", schema)
+		}
+	}
+
+	if _, err := db.ExecContext(ctx, "CREATE SCHEMA public"); err != nil {
+		return err
+	}
+
+	return e.setup(ctx)
+}
+
+// setup creates what the options of Run ask for in a running server.
+func (e *Env) setup(ctx context.Context) error {
+	if err := e.createExtensions(ctx, e.extensions); err != nil {
+		return err
+	}
+
+	if err := e.createDatabases(ctx); err != nil {
+		return err
+	}
+
+	if e.migrations != nil {
+		if err := e.Migrate(ctx, e.migrations); err != nil {
+			return err
+		}
+	}
+
+	return e.grantRoles(ctx)
+}
+
+// ConnectionEnv implements common.Service.
+func (e *Env) ConnectionEnv() map[string]string {
+	return e.EnvVars("")
+}
+
+// EnvVars implements common.Service.
+func (e *Env) EnvVars(prefix string) map[string]string {
+	return common.PrefixEnv(prefix, map[string]string{
+		"DATABASE_URL": e.URI,
+		"PGHOST":       e.DBHost,
+		"PGPORT":       e.DBPort,
+		"PGUSER":       e.DBUser,
+		"PGPASSWORD":   e.DBPass,
+		"PGDATABASE":   e.DBName,
+	})
+}
+
+// Load returns the Env of the named service from a document written by
+// common.WriteJSON. The Env has no container attached, so it must not be terminated.
+func Load(doc *common.Document, name string) (*Env, error) {
+	env := &Env{name: name}
+	if err := doc.Decode(name, env); err != nil {
+		return nil, err
+	}
+
+	return env, nil
+}
+
 func Run(ctx context.Context, opts ...testcontainers.ContainerCustomizer) (*Env, error) {
 	req := testcontainers.GenericContainerRequest{
 		ContainerRequest: testcontainers.ContainerRequest{
@@ -73,15 +345,38 @@ func Run(ctx context.Context, opts ...testcontainers.ContainerCustomizer) (*Env,
 		},
 	}
 
-	for _, e := range opts {
-		_ = e.Customize(&req) //nolint:errcheck
+	pgOpts := collectOptions(opts)
+
+	// after the other customizers, to merge shared_preload_libraries of every
+	// WithExtensions and WithSettings call
+	opts = append(opts, withExtensionNeeds(pgOpts.extensions))
+
+	settings, err := common.Customize(kind, &req, opts...)
+	if err != nil {
+		return nil, err
 	}
 
 	if req.Image == "" {
-		req.Image = defaultImage
+		if req.Image, err = common.ResolveImage(kind); err != nil {
+			return nil, err
+		}
 	}
 
+	alias := common.NetworkAlias(&req)
+	networkName := aliasNetwork(&req)
+
 	var env Env
+	env.name = common.ServiceName(&req, kind)
+	env.log = settings.Logger
+	env.image = req.Image
+	env.cmd = slices.Clone(req.Cmd)
+	env.poolSize = pgOpts.poolSize
+	env.params = pgOpts.params
+	env.extensions = pgOpts.extensions
+	env.migrations = pgOpts.migrations
+	env.databases = pgOpts.databases
+	env.roles = pgOpts.roles
+	env.rec = pgOpts.recorder
 	if v, ok := req.Env[userNameEnvKey]; ok {
 		env.DBUser = v
 	} else {
@@ -104,42 +399,79 @@ func Run(ctx context.Context, opts ...testcontainers.ContainerCustomizer) (*Env,
 	}
 
 	if req.WaitingFor == nil {
-		opts = append(opts, testcontainers.WithWaitStrategy(
-			wait.ForSQL("5432/tcp", "postgres", func(host string, port nat.Port) string {
-				return fmt.Sprintf(
-					"postgres://%s:%s@%s/%s?sslmode=disable",
-					defaultDBUser,
-					defaultDBPass,
-					net.JoinHostPort(host, port.Port()),
-					defaultDBName,
-				)
-			}).
-				WithStartupTimeout(startTimeout),
-		))
-	}
-
-	p, err := postgres.RunContainer(ctx, opts...)
-	if err != nil {
-		return nil, err
+		// host and port are filled by the strategy
+		opts = append(opts, testcontainers.WithWaitStrategy(waitForSQL(postgresPort, env.dsn("", "", env.DBName))))
 	}
 
-	env.URI, err = p.ConnectionString(ctx)
-	if err != nil {
-		return nil, err
+	// the alias of the caller, not the one of the sidecar network
+	internalHost := alias
+
+	if pgOpts.pgbouncer != nil && alias == "" {
+		// a reused container is found by a hash of the request, which cannot
+		// include a network created on every run
+		if settings.Reuse {
+			return nil, errors.Errorf("%s: WithPgBouncer with WithReuse needs a network set with common.WithNetworkAlias", kind)
+		}
+
+		// the sidecar reaches the database over a network of its own
+		nw, err := network.New(ctx, network.WithLabels(common.SessionLabels()))
+		if err != nil {
+			return nil, errors.Wrap(err, "create network")
+		}
+
+		alias, networkName = kind, nw.Name
+		env.removeNetwork = nw.Remove
+		env.network = nw.ID
+		opts = append(opts, common.WithNetworkAlias(networkName, alias))
 	}
-	env.URI += " sslmode=disable"
-	env.Container = p
 
-	port, err := p.Container.MappedPort(ctx, "5432/tcp")
+	opts = append(common.ModuleOptions(&req), opts...)
+
+	p, err := common.Start(ctx, settings,
+		func(ctx context.Context) (*postgres.PostgresContainer, error) {
+			return postgres.Run(ctx, req.Image, opts...)
+		},
+		func(ctx context.Context, p *postgres.PostgresContainer) error {
+			port, err := p.MappedPort(ctx, postgresPort)
+			if err != nil {
+				return err
+			}
+			env.DBPort = port.Port()
+
+			host, err := p.Host(ctx)
+			if err != nil {
+				return err
+			}
+			env.DBHost = host
+			env.URI = env.DSN().URL()
+
+			if err := env.setup(ctx); err != nil {
+				return err
+			}
+
+			if pgOpts.pgbouncer != nil {
+				return env.startPgBouncer(ctx, networkName, alias, pgOpts.pgbouncer)
+			}
+
+			return nil
+		},
+	)
 	if err != nil {
+		if env.removeNetwork != nil {
+			// the container is removed already, ctx may be canceled
+			err = errors.Join(err, env.removeNetwork(context.WithoutCancel(ctx)))
+		}
+
 		return nil, err
 	}
-	env.DBPort = port.Port()
 
-	host, err := p.Container.Host(ctx)
-	if err != nil {
-		return nil, err
+	env.Container = p
+
+	if internalHost != "" {
+		env.InternalDBHost = internalHost
+		env.InternalDBPort = postgresPort.Port()
+		env.InternalURI = env.dsn(internalHost, env.InternalDBPort, env.DBName).URL()
 	}
-	env.DBHost = host
+
 	return &env, nil
 }
@@ -0,0 +1,88 @@
+package psql
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+
+	testcontainers "github.com/testcontainers/testcontainers-go"
+)
+
+func TestTerminateClosesConnections(t *testing.T) {
+	ctx := context.Background()
+
+	// nothing listens there, neither SQL nor Pool connect before the first query
+	env := &Env{DBHost: "127.0.0.1", DBPort: "1", DBUser: "app", DBPass: "app", DBName: "app", poolSize: 3}
+	env.URI = env.databaseURI(env.DBName)
+
+	pool, err := env.Pool(ctx)
+	if err != nil {
+		t.Fatalf("Pool() error = %v", err)
+	}
+
+	if got := pool.Config().MaxConns; got != 3 {
+		t.Errorf("MaxConns = %d, want 3", got)
+	}
+
+	if again, _ := env.Pool(ctx); again != pool {
+		t.Errorf("Pool() is not cached")
+	}
+
+	db, err := env.SQL()
+	if err != nil {
+		t.Fatalf("SQL() error = %v", err)
+	}
+
+	if got := db.Stats().MaxOpenConnections; got != 3 {
+		t.Errorf("MaxOpenConnections = %d, want 3", got)
+	}
+
+	if err := env.Terminate(ctx); err != nil {
+		t.Fatalf("Terminate() error = %v", err)
+	}
+
+	if env.pool != nil || env.db != nil {
+		t.Errorf("Terminate() left cached connections")
+	}
+}
+
+func TestResetKeepsSetup(t *testing.T) {
+	testcontainers.SkipIfProviderIsNotHealthy(t)
+
+	ctx := context.Background()
+	migrations := fstest.MapFS{"1_users.sql": {Data: []byte("CREATE TABLE users (id int);")}}
+
+	pg, err := Run(ctx, WithMigrations(migrations), WithRole(Role{Name: "reader", Password: "reader", Schema: "reader"}))
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	t.Cleanup(func() { _ = pg.Terminate(ctx) })
+
+	db, err := pg.SQL()
+	if err != nil {
+		t.Fatalf("SQL() error = %v", err)
+	}
+
+	if _, err := db.ExecContext(ctx, "INSERT INTO users VALUES (1)"); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+
+	if err := pg.Reset(ctx); err != nil {
+		t.Fatalf("Reset() error = %v", err)
+	}
+
+	var users int
+	if err := db.QueryRowContext(ctx, "SELECT count(*) FROM users").Scan(&users); err != nil {
+		t.Fatalf("users table after Reset: %v", err)
+	}
+
+	if users != 0 {
+		t.Errorf("users has %d rows after Reset, want 0", users)
+	}
+
+	var schema bool
+	if err := db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM pg_namespace WHERE nspname = 'reader')").Scan(&schema); err != nil || !schema {
+		t.Errorf("role schema after Reset = %v, %v, want it recreated", schema, err)
+	}
+}
@@ -0,0 +1,236 @@
+package psql
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+
+	errors "github.com/go-faster/errors"
+	pq "github.com/lib/pq"
+)
+
+type (
+	// Role is a login role created after startup by WithRole.
+	Role struct {
+		Name     string
+		Password string
+
+		// Database is where Schema and Grants apply, the Env database when
+		// empty. It must be the Env database or one of WithDatabases.
+		Database string
+		// Owner makes the role the owner of Database.
+		Owner bool
+		// Schema is created, owned by the role, in Database and becomes the
+		// first entry of the role's search_path there.
+		Schema string
+		// Grants are privileges granted to the role in Database after the
+		// migrations ran, e.g. "SELECT ON ALL TABLES IN SCHEMA public".
+		Grants []string
+	}
+
+	// connKey identifies a connection of SQLFor.
+	connKey struct {
+		database string
+		role     string
+	}
+)
+
+// DSNFor returns the connection parameters of a database of the Env, the Env
+// database or one of WithDatabases, as a role of WithRole. An empty role or
+// DBUser connects as the Env user.
+func (e *Env) DSNFor(database, role string) (DSN, error) {
+	if database != e.DBName && !slices.Contains(e.databases, database) {
+		return DSN{}, errors.Errorf("database %s is not declared, see WithDatabases", database)
+	}
+
+	dsn := e.dsn(e.DBHost, e.DBPort, database)
+
+	if role == "" || role == e.DBUser {
+		return dsn, nil
+	}
+
+	r, ok := e.role(role)
+	if !ok {
+		return DSN{}, errors.Errorf("role %s is not declared, see WithRole", role)
+	}
+
+	dsn.User, dsn.Password = r.Name, r.Password
+
+	return dsn, nil
+}
+
+// SQLFor returns a cached connection to the database as the role, see DSNFor.
+// The connections are closed by Terminate.
+func (e *Env) SQLFor(database, role string) (*sql.DB, error) {
+	if role == e.DBUser {
+		role = ""
+	}
+
+	if database == e.DBName && role == "" {
+		return e.SQL()
+	}
+
+	dsn, err := e.DSNFor(database, role)
+	if err != nil {
+		return nil, err
+	}
+
+	e.dbMu.Lock()
+	defer e.dbMu.Unlock()
+
+	key := connKey{database: database, role: role}
+	if db, ok := e.conns[key]; ok {
+		return db, nil
+	}
+
+	db, err := sql.Open("postgres", dsn.URL())
+	if err != nil {
+		return nil, err
+	}
+
+	if e.poolSize > 0 {
+		db.SetMaxOpenConns(e.poolSize)
+	}
+
+	if e.conns == nil {
+		e.conns = map[connKey]*sql.DB{}
+	}
+
+	e.conns[key] = db
+
+	return db, nil
+}
+
+func (e *Env) role(name string) (Role, bool) {
+	i := slices.IndexFunc(e.roles, func(r Role) bool { return r.Name == name })
+	if i < 0 {
+		return Role{}, false
+	}
+
+	return e.roles[i], true
+}
+
+// createDatabases creates the databases, roles and schemas of WithDatabases
+// and WithRole. Existing ones, e.g. in a reused container, are kept.
+func (e *Env) createDatabases(ctx context.Context) error {
+	if len(e.databases) == 0 && len(e.roles) == 0 {
+		return nil
+	}
+
+	db, err := sql.Open("postgres", e.databaseURI(e.DBName))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	for _, name := range e.databases {
+		var exists bool
+		if err := db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM pg_database WHERE datname = $1)", name).Scan(&exists); err != nil {
+			return err
+		}
+
+		if exists {
+			continue
+		}
+
+		if _, err := db.ExecContext(ctx, "CREATE DATABASE "+pq.QuoteIdentifier(name)); err != nil {
+			return errors.Wrapf(err, "create database %s", name)
+		}
+	}
+
+	for _, r := range e.roles {
+		if err := e.createRole(ctx, db, r); err != nil {
+			return errors.Wrapf(err, "create role %s", r.Name)
+		}
+	}
+
+	return nil
+}
+
+func (e *Env) createRole(ctx context.Context, db *sql.DB, r Role) error {
+	database := e.roleDatabase(r)
+	if database != e.DBName && !slices.Contains(e.databases, database) {
+		return errors.Errorf("database %s is not declared, see WithDatabases", database)
+	}
+
+	var exists bool
+	if err := db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM pg_roles WHERE rolname = $1)", r.Name).Scan(&exists); err != nil {
+		return err
+	}
+
+	verb := "CREATE"
+	if exists {
+		verb = "ALTER"
+	}
+
+	role := pq.QuoteIdentifier(r.Name)
+
+	statements := []string{verb + " ROLE " + role + " LOGIN PASSWORD " + pq.QuoteLiteral(r.Password)}
+
+	if r.Owner {
+		statements = append(statements, "ALTER DATABASE "+pq.QuoteIdentifier(database)+" OWNER TO "+role)
+	}
+
+	if r.Schema != "" {
+		statements = append(statements, "ALTER ROLE "+role+" IN DATABASE "+pq.QuoteIdentifier(database)+
+			" SET search_path = "+pq.QuoteIdentifier(r.Schema)+", public")
+	}
+
+	for _, statement := range statements {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+
+	if r.Schema == "" {
+		return nil
+	}
+
+	return e.execIn(ctx, database,
+		"CREATE SCHEMA IF NOT EXISTS "+pq.QuoteIdentifier(r.Schema)+" AUTHORIZATION "+role)
+}
+
+// grantRoles applies the grants of WithRole.
+func (e *Env) grantRoles(ctx context.Context) error {
+	for _, r := range e.roles {
+		statements := make([]string, 0, len(r.Grants))
+		for _, grant := range r.Grants {
+			statements = append(statements, "GRANT "+grant+" TO "+pq.QuoteIdentifier(r.Name))
+		}
+
+		if err := e.execIn(ctx, e.roleDatabase(r), statements...); err != nil {
+			return errors.Wrapf(err, "grant role %s", r.Name)
+		}
+	}
+
+	return nil
+}
+
+func (e *Env) roleDatabase(r Role) string {
+	if r.Database == "" {
+		return e.DBName
+	}
+
+	return r.Database
+}
+
+// execIn runs the statements as the Env user in the database.
+func (e *Env) execIn(ctx context.Context, database string, statements ...string) error {
+	if len(statements) == 0 {
+		return nil
+	}
+
+	db, err := sql.Open("postgres", e.databaseURI(database))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	for _, statement := range statements {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			return errors.Wrap(err, statement)
+		}
+	}
+
+	return nil
+}
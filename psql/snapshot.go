@@ -0,0 +1,200 @@
+package psql
+
+import (
+	"context"
+	"database/sql"
+
+	errors "github.com/go-faster/errors"
+	pq "github.com/lib/pq"
+)
+
+const (
+	snapshotInfix = "_snapshot_"
+	restoreSuffix = "_restore"
+
+	// maxIdentifierLength is the longest name PostgreSQL keeps without truncation.
+	maxIdentifierLength = 63
+)
+
+// Snapshot captures the current state of the database as a template database
+// named after the snapshot, replacing an earlier snapshot of the same name.
+// Copying requires the database without connections, so the connections of
+// SQL and Pool are closed and other connections are terminated; the next
+// SQL or Pool call reopens them.
+func (e *Env) Snapshot(ctx context.Context, name string) error {
+	snapshot, err := e.snapshotDB(name)
+	if err != nil {
+		return err
+	}
+
+	return e.withAdmin(func(admin *sql.DB) error {
+		if _, err := admin.ExecContext(ctx, "DROP DATABASE IF EXISTS "+pq.QuoteIdentifier(snapshot)+" WITH (FORCE)"); err != nil {
+			return errors.Wrapf(err, "drop snapshot %s", name)
+		}
+
+		if err := copyDatabase(ctx, admin, e.DBName, snapshot); err != nil {
+			return errors.Wrapf(err, "snapshot %s", name)
+		}
+
+		return nil
+	})
+}
+
+// Restore replaces the database with a copy of the snapshot, which stays
+// available for further restores. The copy is made under another name first,
+// so the database is kept when copying fails. The roles of WithRole get their
+// ownership, search_path and grants in the database back. The connections of SQL and Pool are closed
+// and reopened, so values returned by SQL or Pool before must not be used
+// anymore.
+func (e *Env) Restore(ctx context.Context, name string) error {
+	snapshot, err := e.snapshotDB(name)
+	if err != nil {
+		return err
+	}
+
+	e.tmplMu.Lock()
+	isTemplate := e.tmpl != nil
+	e.tmplMu.Unlock()
+
+	if isTemplate {
+		return errors.Errorf("restore %s: database %s is a template, see CloseTemplate", name, e.DBName)
+	}
+
+	err = e.withAdmin(func(admin *sql.DB) error {
+		var exists bool
+
+		err := admin.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM pg_database WHERE datname = $1)", snapshot).Scan(&exists)
+		if err != nil {
+			return err
+		}
+
+		if !exists {
+			return errors.Errorf("snapshot %s does not exist", name)
+		}
+
+		// the database is replaced only once the copy succeeded
+		restored := fitIdentifier(e.DBName, restoreSuffix)
+
+		// left over by a restore that failed to rename
+		if err := dropDatabase(ctx, admin, restored); err != nil {
+			return errors.Wrapf(err, "restore %s", name)
+		}
+
+		if err := copyDatabase(ctx, admin, snapshot, restored); err != nil {
+			return errors.Wrapf(err, "restore %s", name)
+		}
+
+		if _, err := admin.ExecContext(ctx, "DROP DATABASE "+pq.QuoteIdentifier(e.DBName)+" WITH (FORCE)"); err != nil {
+			return errors.Join(
+				errors.Wrapf(err, "restore %s: drop database %s", name, e.DBName),
+				dropDatabase(ctx, admin, restored),
+			)
+		}
+
+		query := "ALTER DATABASE " + pq.QuoteIdentifier(restored) + " RENAME TO " + pq.QuoteIdentifier(e.DBName)
+		if _, err := admin.ExecContext(ctx, query); err != nil {
+			return errors.Wrapf(err, "restore %s: rename %s", name, restored)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// the owner, role settings and grants of the database itself are not copied
+	if err := e.createDatabases(ctx); err != nil {
+		return errors.Wrapf(err, "restore %s", name)
+	}
+
+	if err := e.grantRoles(ctx); err != nil {
+		return errors.Wrapf(err, "restore %s", name)
+	}
+
+	db, err := e.SQL()
+	if err != nil {
+		return err
+	}
+
+	return db.PingContext(ctx)
+}
+
+// DropSnapshot removes the snapshot. Removing a missing snapshot is not an error.
+func (e *Env) DropSnapshot(ctx context.Context, name string) error {
+	snapshot, err := e.snapshotDB(name)
+	if err != nil {
+		return err
+	}
+
+	admin, err := e.openAdmin()
+	if err != nil {
+		return err
+	}
+	defer admin.Close()
+
+	if _, err := admin.ExecContext(ctx, "DROP DATABASE IF EXISTS "+pq.QuoteIdentifier(snapshot)+" WITH (FORCE)"); err != nil {
+		return errors.Wrapf(err, "drop snapshot %s", name)
+	}
+
+	return nil
+}
+
+// snapshotDB returns the name of the database holding the snapshot.
+func (e *Env) snapshotDB(name string) (string, error) {
+	if name == "" {
+		return "", errors.New("snapshot name is empty")
+	}
+
+	db := e.DBName + snapshotInfix + name
+	if len(db) > maxIdentifierLength {
+		return "", errors.Errorf("snapshot name %q is too long: %s exceeds %d bytes", name, db, maxIdentifierLength)
+	}
+
+	return db, nil
+}
+
+// dropDatabase drops the database if it exists, disconnecting its clients.
+func dropDatabase(ctx context.Context, admin *sql.DB, name string) error {
+	if _, err := admin.ExecContext(ctx, "DROP DATABASE IF EXISTS "+pq.QuoteIdentifier(name)+" WITH (FORCE)"); err != nil {
+		return errors.Wrapf(err, "drop database %s", name)
+	}
+
+	return nil
+}
+
+// withAdmin closes the connections of SQL and Pool and runs fn with an admin connection.
+func (e *Env) withAdmin(fn func(admin *sql.DB) error) error {
+	if err := e.closeSQL(); err != nil {
+		return err
+	}
+
+	admin, err := e.openAdmin()
+	if err != nil {
+		return err
+	}
+
+	return errors.Join(fn(admin), admin.Close())
+}
+
+// copyDatabase creates database to from template from, terminating the
+// connections to from first and once more if somebody reconnected meanwhile.
+func copyDatabase(ctx context.Context, admin *sql.DB, from, to string) error {
+	query := "CREATE DATABASE " + pq.QuoteIdentifier(to) + " TEMPLATE " + pq.QuoteIdentifier(from)
+
+	var err error
+
+	for range 2 {
+		if err = terminateBackends(ctx, admin, from); err != nil {
+			return err
+		}
+
+		_, err = admin.ExecContext(ctx, query)
+
+		var pqErr *pq.Error
+		if !errors.As(err, &pqErr) || pqErr.Code != objectInUse {
+			return err
+		}
+	}
+
+	return err
+}
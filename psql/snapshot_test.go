@@ -0,0 +1,101 @@
+package psql
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	testcontainers "github.com/testcontainers/testcontainers-go"
+)
+
+func TestSnapshotDB(t *testing.T) {
+	env := &Env{DBName: "app"}
+
+	if got, err := env.snapshotDB("seeded"); err != nil || got != "app_snapshot_seeded" {
+		t.Errorf("snapshotDB() = %q, %v, want app_snapshot_seeded", got, err)
+	}
+
+	if _, err := env.snapshotDB(""); err == nil {
+		t.Errorf("snapshotDB() with empty name expected error")
+	}
+
+	if _, err := env.snapshotDB(strings.Repeat("x", 60)); err == nil {
+		t.Errorf("snapshotDB() with long name expected error")
+	}
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	testcontainers.SkipIfProviderIsNotHealthy(t)
+
+	ctx := context.Background()
+
+	pg, err := Run(ctx, WithRole(Role{Name: "reader", Password: "reader", Owner: true, Schema: "reader"}))
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	t.Cleanup(func() { _ = pg.Terminate(ctx) })
+
+	exec := func(query string) {
+		t.Helper()
+
+		db, err := pg.SQL()
+		if err != nil {
+			t.Fatalf("SQL() error = %v", err)
+		}
+
+		if _, err := db.ExecContext(ctx, query); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+
+	exec("CREATE TABLE users (id int)")
+	exec("INSERT INTO users VALUES (1)")
+
+	if err := pg.Snapshot(ctx, "seeded"); err != nil {
+		t.Fatalf("Snapshot() error = %v", err)
+	}
+
+	exec("INSERT INTO users VALUES (2)")
+
+	if err := pg.Restore(ctx, "missing"); err == nil {
+		t.Errorf("Restore() of a missing snapshot expected error")
+	}
+
+	for range 2 {
+		if err := pg.Restore(ctx, "seeded"); err != nil {
+			t.Fatalf("Restore() error = %v", err)
+		}
+
+		db, err := pg.SQL()
+		if err != nil {
+			t.Fatalf("SQL() error = %v", err)
+		}
+
+		var count int
+		if err := db.QueryRowContext(ctx, "SELECT count(*) FROM users").Scan(&count); err != nil {
+			t.Fatalf("count: %v", err)
+		}
+
+		if count != 1 {
+			t.Errorf("users has %d rows after Restore, want 1", count)
+		}
+
+		var owner string
+		var settings int
+		if err := db.QueryRowContext(ctx,
+			`SELECT pg_get_userbyid(d.datdba), (SELECT count(*) FROM pg_db_role_setting s WHERE s.setdatabase = d.oid)
+			FROM pg_database d WHERE d.datname = current_database()`,
+		).Scan(&owner, &settings); err != nil {
+			t.Fatalf("read database owner: %v", err)
+		}
+
+		if owner != "reader" || settings != 1 {
+			t.Errorf("after Restore owner = %s, role settings = %d, want reader and 1", owner, settings)
+		}
+	}
+
+	if err := pg.DropSnapshot(ctx, "seeded"); err != nil {
+		t.Errorf("DropSnapshot() error = %v", err)
+	}
+}
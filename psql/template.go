@@ -0,0 +1,309 @@
+package psql
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"unicode/utf8"
+
+	errors "github.com/go-faster/errors"
+	pq "github.com/lib/pq"
+)
+
+const (
+	// maintenanceDB is the database used to create and drop clones, as the
+	// template itself must have no connections while it is copied.
+	// fallbackMaintenanceDB is used when the Env database is maintenanceDB.
+	maintenanceDB         = "postgres"
+	fallbackMaintenanceDB = "template1"
+
+	// objectInUse is reported when the template has connections during a copy.
+	objectInUse = "55006"
+)
+
+type (
+	// Database is a copy of the template database owned by one test.
+	Database struct {
+		Name string
+		URI  string
+		DB   *sql.DB
+	}
+
+	// TemplateOption configures MarkTemplate.
+	TemplateOption func(*templateConfig)
+
+	templateConfig struct {
+		poolSize int
+	}
+
+	// template creates clones of the template database, keeping a pool of
+	// ready ones filled in the background.
+	template struct {
+		env   *Env
+		admin *sql.DB
+		name  string
+
+		createMu sync.Mutex // CREATE DATABASE ... TEMPLATE runs one at a time
+		seq      atomic.Uint64
+
+		ready  chan string
+		cancel context.CancelFunc
+		done   chan struct{}
+	}
+)
+
+// WithClonePool keeps size clones created in advance, so NewDatabase in
+// parallel tests does not wait for CREATE DATABASE.
+func WithClonePool(size int) TemplateOption {
+	return func(c *templateConfig) {
+		c.poolSize = size
+	}
+}
+
+// MarkTemplate turns the database of the Env, usually after migrations, into
+// a template for NewDatabase. The cached connections of SQL and Pool are
+// closed and other connections to the database are terminated, as PostgreSQL
+// copies only databases nobody is connected to.
+func (e *Env) MarkTemplate(ctx context.Context, opts ...TemplateOption) error {
+	cfg := templateConfig{}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	e.tmplMu.Lock()
+	defer e.tmplMu.Unlock()
+
+	if e.tmpl != nil {
+		return errors.Errorf("database %s is already a template", e.DBName)
+	}
+
+	admin, err := e.openAdmin()
+	if err != nil {
+		return err
+	}
+
+	if err := e.closeSQL(); err != nil {
+		return errors.Join(err, admin.Close())
+	}
+
+	if _, err := admin.ExecContext(ctx, "ALTER DATABASE "+pq.QuoteIdentifier(e.DBName)+" IS_TEMPLATE true"); err != nil {
+		return errors.Join(errors.Wrapf(err, "mark %s as template", e.DBName), admin.Close())
+	}
+
+	t := &template{
+		env:   e,
+		admin: admin,
+		name:  e.DBName,
+		ready: make(chan string, cfg.poolSize),
+		done:  make(chan struct{}),
+	}
+
+	if err := t.disconnect(ctx); err != nil {
+		return errors.Join(err, admin.Close())
+	}
+
+	fillCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	t.cancel = cancel
+
+	if cfg.poolSize > 0 {
+		go t.fill(fillCtx)
+	} else {
+		close(t.done)
+	}
+
+	e.tmpl = t
+
+	return nil
+}
+
+// NewDatabase returns a fresh copy of the template database for the test,
+// taken from the pool when one is ready. The copy is dropped on tb.Cleanup.
+// MarkTemplate must be called first.
+func (e *Env) NewDatabase(tb testing.TB) *Database {
+	tb.Helper()
+
+	e.tmplMu.Lock()
+	t := e.tmpl
+	e.tmplMu.Unlock()
+
+	if t == nil {
+		tb.Fatalf("psql: NewDatabase requires MarkTemplate")
+	}
+
+	ctx := context.Background()
+
+	var name string
+
+	select {
+	case name = <-t.ready:
+	default:
+		var err error
+		if name, err = t.clone(ctx); err != nil {
+			tb.Fatalf("psql: %v", err)
+		}
+	}
+
+	db := &Database{Name: name, URI: e.databaseURI(name)}
+
+	var err error
+	if db.DB, err = sql.Open("postgres", db.URI); err != nil {
+		tb.Fatalf("psql: open %s: %v", name, err)
+	}
+
+	tb.Cleanup(func() {
+		if err := errors.Join(db.DB.Close(), t.drop(ctx, name)); err != nil {
+			tb.Errorf("psql: %v", err)
+		}
+	})
+
+	return db
+}
+
+// CloseTemplate stops filling the pool and drops the clones nobody took.
+// The database stays a template.
+func (e *Env) CloseTemplate(ctx context.Context) error {
+	e.tmplMu.Lock()
+	t := e.tmpl
+	e.tmpl = nil
+	e.tmplMu.Unlock()
+
+	if t == nil {
+		return nil
+	}
+
+	t.cancel()
+	<-t.done
+
+	var errs []error
+
+	for {
+		select {
+		case name := <-t.ready:
+			errs = append(errs, t.drop(ctx, name))
+		default:
+			return errors.Join(append(errs, t.admin.Close())...)
+		}
+	}
+}
+
+// fill keeps the pool full until ctx is canceled.
+func (t *template) fill(ctx context.Context) {
+	defer close(t.done)
+
+	for {
+		name, err := t.clone(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				t.env.logger().WarnContext(ctx, "clone pool stopped, databases are created on demand", slog.Any("error", err))
+			}
+
+			return
+		}
+
+		select {
+		case t.ready <- name:
+		case <-ctx.Done():
+			_ = t.drop(context.WithoutCancel(ctx), name) //nolint:errcheck // best effort on shutdown
+
+			return
+		}
+	}
+}
+
+// clone creates a new copy of the template.
+func (t *template) clone(ctx context.Context) (string, error) {
+	t.createMu.Lock()
+	defer t.createMu.Unlock()
+
+	name := cloneName(t.name, os.Getpid(), t.seq.Add(1))
+	query := "CREATE DATABASE " + pq.QuoteIdentifier(name) + " TEMPLATE " + pq.QuoteIdentifier(t.name)
+
+	_, err := t.admin.ExecContext(ctx, query)
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == objectInUse {
+		// somebody connected to the template, e.g. through Env.SQL
+		if err = t.disconnect(ctx); err == nil {
+			_, err = t.admin.ExecContext(ctx, query)
+		}
+	}
+
+	if err != nil {
+		return "", errors.Wrapf(err, "create database %s from template %s", name, t.name)
+	}
+
+	return name, nil
+}
+
+// cloneName names a copy of the template; the pid keeps names unique when
+// processes share the server.
+func cloneName(base string, pid int, seq uint64) string {
+	return fitIdentifier(base, fmt.Sprintf("_%d_%d", pid, seq))
+}
+
+// fitIdentifier appends suffix to base. A base too long to fit is cut and
+// followed by its hash, as PostgreSQL silently truncates longer names.
+func fitIdentifier(base, suffix string) string {
+	if len(base)+len(suffix) <= maxIdentifierLength {
+		return base + suffix
+	}
+
+	sum := sha256.Sum256([]byte(base))
+	hash := "_" + hex.EncodeToString(sum[:4])
+
+	cut := maxIdentifierLength - len(suffix) - len(hash)
+	for cut > 0 && !utf8.RuneStart(base[cut]) {
+		cut--
+	}
+
+	return base[:cut] + hash + suffix
+}
+
+func (t *template) drop(ctx context.Context, name string) error {
+	if _, err := t.admin.ExecContext(ctx, "DROP DATABASE IF EXISTS "+pq.QuoteIdentifier(name)+" WITH (FORCE)"); err != nil {
+		return errors.Wrapf(err, "drop database %s", name)
+	}
+
+	return nil
+}
+
+// disconnect terminates the connections to the template.
+func (t *template) disconnect(ctx context.Context) error {
+	return terminateBackends(ctx, t.admin, t.name)
+}
+
+// terminateBackends terminates the connections to the database.
+func terminateBackends(ctx context.Context, admin *sql.DB, name string) error {
+	_, err := admin.ExecContext(ctx,
+		"SELECT pg_terminate_backend(pid) FROM pg_stat_activity WHERE datname = $1 AND pid <> pg_backend_pid()",
+		name,
+	)
+	if err != nil {
+		return errors.Wrapf(err, "disconnect from %s", name)
+	}
+
+	return nil
+}
+
+// openAdmin opens a connection to another database of the server, for
+// statements that need the Env database without connections.
+func (e *Env) openAdmin() (*sql.DB, error) {
+	name := maintenanceDB
+	if e.DBName == maintenanceDB {
+		name = fallbackMaintenanceDB
+	}
+
+	return sql.Open("postgres", e.databaseURI(name))
+}
+
+// databaseURI returns the URI of another database of the server.
+func (e *Env) databaseURI(name string) string {
+	return e.dsn(e.DBHost, e.DBPort, name).URL()
+}
@@ -0,0 +1,123 @@
+package psql
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	testcontainers "github.com/testcontainers/testcontainers-go"
+)
+
+func TestCloneName(t *testing.T) {
+	if got := cloneName("app", 42, 7); got != "app_42_7" {
+		t.Errorf("cloneName() = %q, want app_42_7", got)
+	}
+
+	tests := []string{
+		strings.Repeat("x", 63),
+		strings.Repeat("x", 55),
+		strings.Repeat("é", 40),
+	}
+
+	for _, base := range tests {
+		a := cloneName(base, 123456, 1)
+		b := cloneName(base, 123456, 2)
+		other := cloneName(base+"y", 123456, 1)
+
+		if len(a) > maxIdentifierLength || !utf8.ValidString(a) {
+			t.Errorf("cloneName(%q) = %q, want a valid name of at most %d bytes", base, a, maxIdentifierLength)
+		}
+
+		if a == b || a == other {
+			t.Errorf("cloneName(%q) = %q is not unique", base, a)
+		}
+
+		if !strings.HasSuffix(a, "_123456_1") {
+			t.Errorf("cloneName(%q) = %q lost the pid and sequence", base, a)
+		}
+	}
+}
+
+func TestCloseTemplateWithoutTemplate(t *testing.T) {
+	env := &Env{DBName: "app"}
+
+	if err := env.CloseTemplate(context.Background()); err != nil {
+		t.Errorf("CloseTemplate() error = %v", err)
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	testcontainers.SkipIfProviderIsNotHealthy(t)
+
+	ctx := context.Background()
+
+	pg, err := Run(ctx)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	t.Cleanup(func() { _ = pg.Terminate(ctx) })
+
+	db, err := pg.SQL()
+	if err != nil {
+		t.Fatalf("SQL() error = %v", err)
+	}
+
+	if _, err := db.ExecContext(ctx, "CREATE TABLE users (id int PRIMARY KEY); INSERT INTO users VALUES (1)"); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+
+	if err := pg.MarkTemplate(ctx, WithClonePool(2)); err != nil {
+		t.Fatalf("MarkTemplate() error = %v", err)
+	}
+
+	if err := pg.MarkTemplate(ctx); err == nil {
+		t.Errorf("second MarkTemplate() expected error")
+	}
+
+	t.Run("clones", func(t *testing.T) {
+		for i := range 4 {
+			t.Run(fmt.Sprint(i), func(t *testing.T) {
+				t.Parallel()
+
+				clone := pg.NewDatabase(t)
+
+				// every test sees the template rows and none of the others' writes
+				if _, err := clone.DB.ExecContext(ctx, "INSERT INTO users VALUES ($1)", i+2); err != nil {
+					t.Fatalf("insert: %v", err)
+				}
+
+				var count int
+				if err := clone.DB.QueryRowContext(ctx, "SELECT count(*) FROM users").Scan(&count); err != nil {
+					t.Fatalf("count: %v", err)
+				}
+
+				if count != 2 {
+					t.Errorf("%s has %d users, want 2", clone.Name, count)
+				}
+			})
+		}
+	})
+
+	if err := pg.CloseTemplate(ctx); err != nil {
+		t.Fatalf("CloseTemplate() error = %v", err)
+	}
+
+	admin, err := pg.openAdmin()
+	if err != nil {
+		t.Fatalf("openAdmin() error = %v", err)
+	}
+
+	defer admin.Close()
+
+	clones, err := queryStrings(ctx, admin, `SELECT datname FROM pg_database WHERE datname LIKE $1`, pg.DBName+`\_%`)
+	if err != nil {
+		t.Fatalf("list databases: %v", err)
+	}
+
+	if len(clones) != 0 {
+		t.Errorf("clones left after CloseTemplate: %v", clones)
+	}
+}
@@ -3,8 +3,10 @@ package redis
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
+	errors "github.com/go-faster/errors"
 	testcontainers "github.com/testcontainers/testcontainers-go"
 	redis "github.com/testcontainers/testcontainers-go/modules/redis"
 	wait "github.com/testcontainers/testcontainers-go/wait"
@@ -18,13 +20,85 @@ type (
 		Address     string
 		AddressHost string
 		AddressPort string
+
+		// InternalAddress is alias:6379 when the container has a network alias.
+		InternalAddress string
+
+		name string
 	}
 )
 
+const kind = "redis"
+
 var (
-	defaultImage = common.DockerProxy("redis:7.2.2-alpine")
+	_ common.Service  = (*Env)(nil)
+	_ common.Resetter = (*Env)(nil)
 )
 
+// ServiceName implements common.Service.
+func (e *Env) ServiceName() string {
+	return e.name
+}
+
+// Kind implements common.Service.
+func (e *Env) Kind() string {
+	return kind
+}
+
+// Endpoints implements common.Service.
+func (e *Env) Endpoints() map[string]string {
+	endpoints := map[string]string{
+		"redis": e.Address,
+	}
+	common.AddInternalEndpoint(endpoints, "redis", e.InternalAddress)
+
+	return endpoints
+}
+
+// HealthCheck implements common.Service.
+func (e *Env) HealthCheck(ctx context.Context) error {
+	return common.CheckTCP(ctx, e.Address)
+}
+
+// Reset implements common.Resetter. It removes all keys of all databases.
+func (e *Env) Reset(ctx context.Context) error {
+	out, err := common.Exec(ctx, e.Container, "redis-cli", "FLUSHALL")
+	if err != nil {
+		return err
+	}
+
+	// redis-cli exits with 0 on error replies such as NOAUTH or READONLY
+	if reply := strings.TrimSpace(out); reply != "OK" {
+		return errors.Errorf("redis-cli FLUSHALL: %s", reply)
+	}
+
+	return nil
+}
+
+// ConnectionEnv implements common.Service.
+func (e *Env) ConnectionEnv() map[string]string {
+	return e.EnvVars("")
+}
+
+// EnvVars implements common.Service.
+func (e *Env) EnvVars(prefix string) map[string]string {
+	return common.PrefixEnv(prefix, map[string]string{
+		"REDIS_ADDR": e.Address,
+		"REDIS_URL":  "redis://" + e.Address,
+	})
+}
+
+// Load returns the Env of the named service from a document written by
+// common.WriteJSON. The Env has no container attached, so it must not be terminated.
+func Load(doc *common.Document, name string) (*Env, error) {
+	env := &Env{name: name}
+	if err := doc.Decode(name, env); err != nil {
+		return nil, err
+	}
+
+	return env, nil
+}
+
 func Run(ctx context.Context, opts ...testcontainers.ContainerCustomizer) (*Env, error) {
 	req := testcontainers.GenericContainerRequest{
 		ContainerRequest: testcontainers.ContainerRequest{
@@ -32,15 +106,21 @@ func Run(ctx context.Context, opts ...testcontainers.ContainerCustomizer) (*Env,
 		},
 	}
 
-	for _, e := range opts {
-		_ = e.Customize(&req) //nolint:errcheck
+	settings, err := common.Customize(kind, &req, opts...)
+	if err != nil {
+		return nil, err
 	}
 
 	var env Env
 	if req.Image == "" {
-		req.Image = defaultImage
+		if req.Image, err = common.ResolveImage(kind); err != nil {
+			return nil, err
+		}
 	}
 
+	alias := common.NetworkAlias(&req)
+	env.name = common.ServiceName(&req, kind)
+
 	if req.WaitingFor == nil {
 		opts = append(opts, testcontainers.WithWaitStrategy(wait.
 			ForExposedPort().
@@ -48,25 +128,36 @@ func Run(ctx context.Context, opts ...testcontainers.ContainerCustomizer) (*Env,
 		))
 	}
 
-	container, err := redis.RunContainer(ctx, opts...)
-	if err != nil {
-		return nil, err
-	}
-	env.Container = container
+	opts = append(common.ModuleOptions(&req), opts...)
 
-	host, err := container.Host(ctx)
-	if err != nil {
-		return nil, err
-	}
+	container, err := common.Start(ctx, settings,
+		func(ctx context.Context) (*redis.RedisContainer, error) {
+			return redis.Run(ctx, req.Image, opts...)
+		},
+		func(ctx context.Context, container *redis.RedisContainer) error {
+			host, err := container.Host(ctx)
+			if err != nil {
+				return err
+			}
+
+			port, err := container.MappedPort(ctx, "6379/tcp")
+			if err != nil {
+				return err
+			}
+
+			env.AddressHost = host
+			env.AddressPort = port.Port()
 
-	port, err := container.MappedPort(ctx, "6379/tcp")
+			return nil
+		},
+	)
 	if err != nil {
 		return nil, err
 	}
 
-	env.AddressHost = host
-	env.AddressPort = port.Port()
+	env.Container = container
 	env.Address = fmt.Sprintf("%s:%s", env.AddressHost, env.AddressPort)
+	env.InternalAddress = common.InternalAddress(alias, "6379")
 
 	return &env, nil
 }
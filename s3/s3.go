@@ -8,7 +8,9 @@ import (
 	credentials "github.com/aws/aws-sdk-go/aws/credentials"
 	session "github.com/aws/aws-sdk-go/aws/session"
 	s3 "github.com/aws/aws-sdk-go/service/s3"
+	s3manager "github.com/aws/aws-sdk-go/service/s3/s3manager"
 	nat "github.com/docker/go-connections/nat"
+	errors "github.com/go-faster/errors"
 	testcontainers "github.com/testcontainers/testcontainers-go"
 	tcLocalstack "github.com/testcontainers/testcontainers-go/modules/localstack"
 
@@ -23,11 +25,19 @@ type (
 		SecretAccessKey string
 		Region          string
 		Token           string
+
+		// InternalEndpointURL is alias:4566 when the container has a network alias.
+		InternalEndpointURL string
+
+		name string
 	}
 )
 
+const kind = "s3"
+
 var (
-	defaultImage = common.DockerProxy("localstack/localstack:1.4.0")
+	_ common.Service  = (*Env)(nil)
+	_ common.Resetter = (*Env)(nil)
 )
 
 func (env *Env) GetS3Client() (*s3.S3, error) {
@@ -52,33 +62,143 @@ func (env *Env) GetS3Client() (*s3.S3, error) {
 	return s3Client, nil
 }
 
-func Run(ctx context.Context, opts ...testcontainers.ContainerCustomizer) (*Env, error) {
-	opts = append(opts,
-		testcontainers.WithImage(defaultImage),
-		testcontainers.WithImageSubstitutors(common.NewImageSubstitutor()),
-	)
+// ServiceName implements common.Service.
+func (env *Env) ServiceName() string {
+	return env.name
+}
+
+// Kind implements common.Service.
+func (env *Env) Kind() string {
+	return kind
+}
+
+// Endpoints implements common.Service.
+func (env *Env) Endpoints() map[string]string {
+	endpoints := map[string]string{
+		"s3": env.EndpointURL,
+	}
+	common.AddInternalEndpoint(endpoints, "s3", env.InternalEndpointURL)
+
+	return endpoints
+}
+
+// HealthCheck implements common.Service.
+func (env *Env) HealthCheck(ctx context.Context) error {
+	return common.CheckHTTP(ctx, "http://"+env.EndpointURL+"/_localstack/health")
+}
+
+// Reset implements common.Resetter. It deletes all buckets with their objects.
+func (env *Env) Reset(ctx context.Context) error {
+	client, err := env.GetS3Client()
+	if err != nil {
+		return err
+	}
 
-	lsContainer, err := tcLocalstack.RunContainer(ctx, opts...)
+	buckets, err := client.ListBucketsWithContext(ctx, &s3.ListBucketsInput{})
 	if err != nil {
+		return err
+	}
+
+	for _, bucket := range buckets.Buckets {
+		iter := s3manager.NewDeleteListIterator(client, &s3.ListObjectsInput{Bucket: bucket.Name})
+		if err := s3manager.NewBatchDeleteWithClient(client).Delete(ctx, iter); err != nil {
+			return errors.Wrapf(err, "empty bucket %s", aws.StringValue(bucket.Name))
+		}
+
+		if _, err := client.DeleteBucketWithContext(ctx, &s3.DeleteBucketInput{Bucket: bucket.Name}); err != nil {
+			return errors.Wrapf(err, "delete bucket %s", aws.StringValue(bucket.Name))
+		}
+	}
+
+	return nil
+}
+
+// ConnectionEnv implements common.Service.
+func (env *Env) ConnectionEnv() map[string]string {
+	return env.EnvVars("")
+}
+
+// EnvVars implements common.Service.
+func (env *Env) EnvVars(prefix string) map[string]string {
+	return common.PrefixEnv(prefix, map[string]string{
+		"AWS_ENDPOINT_URL":      "http://" + env.EndpointURL,
+		"AWS_ACCESS_KEY_ID":     env.AccessKeyID,
+		"AWS_SECRET_ACCESS_KEY": env.SecretAccessKey,
+		"AWS_SESSION_TOKEN":     env.Token,
+		"AWS_REGION":            env.Region,
+	})
+}
+
+// Load returns the Env of the named service from a document written by
+// common.WriteJSON. The Env has no container attached, so it must not be terminated.
+func Load(doc *common.Document, name string) (*Env, error) {
+	env := &Env{name: name}
+	if err := doc.Decode(name, env); err != nil {
 		return nil, err
 	}
 
-	mappedPort, err := lsContainer.MappedPort(ctx, nat.Port("4566/tcp"))
+	return env, nil
+}
+
+func Run(ctx context.Context, opts ...testcontainers.ContainerCustomizer) (*Env, error) {
+	req := testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			ImageSubstitutors: common.ImageSubstitutors(),
+		},
+	}
+
+	settings, err := common.Customize(kind, &req, opts...)
 	if err != nil {
 		return nil, err
 	}
 
-	host, err := lsContainer.Container.Host(ctx)
+	image := req.Image
+	if image == "" {
+		if image, err = common.ResolveImage(kind); err != nil {
+			return nil, err
+		}
+	}
+
+	alias := common.NetworkAlias(&req)
+
+	opts = append(common.ModuleOptions(&req), opts...)
+
+	var endpointURL string
+
+	lsContainer, err := common.Start(ctx, settings,
+		func(ctx context.Context) (*tcLocalstack.LocalStackContainer, error) {
+			return tcLocalstack.Run(ctx, image, opts...)
+		},
+		func(ctx context.Context, lsContainer *tcLocalstack.LocalStackContainer) error {
+			mappedPort, err := lsContainer.MappedPort(ctx, nat.Port("4566/tcp"))
+			if err != nil {
+				return err
+			}
+
+			host, err := lsContainer.Host(ctx)
+			if err != nil {
+				return err
+			}
+
+			endpointURL = fmt.Sprintf("%s:%d", host, mappedPort.Int())
+
+			return nil
+		},
+	)
 	if err != nil {
 		return nil, err
 	}
 
 	return &Env{
 		Container:       lsContainer,
-		EndpointURL:     fmt.Sprintf("%s:%d", host, mappedPort.Int()),
+		EndpointURL:     endpointURL,
 		AccessKeyID:     "access_key_id",
 		SecretAccessKey: "secret_access_key",
 		Token:           "token",
 		Region:          "us-east-1",
+
+		InternalEndpointURL: common.InternalAddress(alias, "4566"),
+
+		name: common.ServiceName(&req, kind),
 	}, nil
 }
@@ -6,6 +6,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
+	"net"
 	"os"
 	"strings"
 	"time"
@@ -30,18 +32,89 @@ type Env struct {
 	HostIP         string // Container host IP
 	SOCKS5Port     string
 	HTTPPort       string
+
+	// Internal* fields are set when the container has a network alias,
+	// see common.WithNetworkAlias.
+	InternalSOCKS5ProxyURL string // socks5://alias:port
+	InternalHTTPProxyURL   string // http://alias:port
+
+	name string
+}
+
+const kind = "singbox"
+
+var _ common.Service = (*Env)(nil)
+
+// ServiceName implements common.Service.
+func (e *Env) ServiceName() string {
+	return e.name
+}
+
+// Kind implements common.Service.
+func (e *Env) Kind() string {
+	return kind
+}
+
+// Endpoints implements common.Service.
+// TUN-only configurations have no endpoints.
+func (e *Env) Endpoints() map[string]string {
+	endpoints := map[string]string{}
+
+	if e.SOCKS5ProxyURL != "" {
+		endpoints["socks5"] = e.SOCKS5ProxyURL
+	}
+	if e.HTTPProxyURL != "" {
+		endpoints["http"] = e.HTTPProxyURL
+	}
+
+	common.AddInternalEndpoint(endpoints, "socks5", e.InternalSOCKS5ProxyURL)
+	common.AddInternalEndpoint(endpoints, "http", e.InternalHTTPProxyURL)
+
+	return endpoints
+}
+
+// HealthCheck implements common.Service.
+// For TUN-only configurations it only checks that the container is running.
+func (e *Env) HealthCheck(ctx context.Context) error {
+	if e.SOCKS5Port != "" {
+		return common.CheckTCP(ctx, net.JoinHostPort(e.HostIP, e.SOCKS5Port))
+	}
+	if e.HTTPPort != "" {
+		return common.CheckTCP(ctx, net.JoinHostPort(e.HostIP, e.HTTPPort))
+	}
+
+	return common.CheckRunning(ctx, e.Container)
+}
+
+// ConnectionEnv implements common.Service.
+func (e *Env) ConnectionEnv() map[string]string {
+	return e.EnvVars("")
 }
 
-// getDefaultImage returns the sing-box image to use.
-// It checks the SINGBOX_IMAGE environment variable first,
-// falling back to the custom GHCR image if not set.
-func getSingBoxImage() string {
-	if img := os.Getenv("SINGBOX_IMAGE"); img != "" {
-		return img
+// EnvVars implements common.Service.
+func (e *Env) EnvVars(prefix string) map[string]string {
+	env := map[string]string{}
+
+	if e.SOCKS5ProxyURL != "" {
+		env["ALL_PROXY"] = e.SOCKS5ProxyURL
+	}
+	if e.HTTPProxyURL != "" {
+		env["HTTP_PROXY"] = e.HTTPProxyURL
+		env["HTTPS_PROXY"] = e.HTTPProxyURL
 	}
-	// Use custom sing-box image from GHCR with additional tools (curl, wget, time, etc.)
-	// This image is built from Dockerfile-sing-box with all necessary utilities for VPN testing
-	return "ghcr.io/sagernet/sing-box"
+
+	return common.PrefixEnv(prefix, env)
+}
+
+// Load returns the Env of the named service from a document written by
+// common.WriteJSON. The Env has no container attached, so it must not be terminated.
+func Load(doc *common.Document, name string) (*Env, error) {
+	env := &Env{name: name}
+	if err := doc.Decode(name, env); err != nil {
+		return nil, err
+	}
+
+	return env, nil
 }
 
 // WithNetworks returns a customizer that connects the container to the specified networks
@@ -85,23 +158,24 @@ func WithVersion(version string) testcontainers.ContainerCustomizer {
 func CreateNetworkWithMTU(ctx context.Context, mtu int) (networkID string, cleanup func() error, err error) {
 	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 	if err != nil {
-		return "", nil, errors.Errorf("failed to create docker client: %w", err)
+		return "", nil, errors.Wrap(err, "failed to create docker client")
 	}
 
 	networkName := fmt.Sprintf("singbox-mtu%d-%d", mtu, time.Now().Unix())
 
+	labels := common.SessionLabels()
+	labels["goat.singbox"] = "true"
+
 	networkResp, err := cli.NetworkCreate(ctx, networkName, network.CreateOptions{
 		Driver: "bridge",
 		Options: map[string]string{
 			"com.docker.network.driver.mtu": fmt.Sprintf("%d", mtu),
 		},
-		Labels: map[string]string{
-			"goat.singbox": "true",
-		},
+		Labels: labels,
 	})
 	if err != nil {
 		cli.Close()
-		return "", nil, errors.Errorf("failed to create network: %w", err)
+		return "", nil, errors.Wrap(err, "failed to create network")
 	}
 
 	cleanup = func() error {
@@ -123,21 +197,24 @@ type singBoxConfig struct {
 
 // parseConfigPorts reads the sing-box config and extracts SOCKS5 and HTTP proxy ports
 // Returns 0 for ports if not found (e.g., for TUN-only configs)
-func parseConfigPorts(configPath string) (socks5Port, httpPort int, hasTUN bool, err error) {
+func parseConfigPorts(configPath string, log *slog.Logger) (socks5Port, httpPort int, hasTUN bool, err error) {
 	data, err := os.ReadFile(configPath)
 	if err != nil {
-		return 0, 0, false, errors.Errorf("failed to read config file: %w", err)
+		return 0, 0, false, errors.Wrap(err, "failed to read config file")
 	}
 
 	var config singBoxConfig
 	if err := json.Unmarshal(data, &config); err != nil {
-		return 0, 0, false, errors.Errorf("failed to parse config JSON: %w", err)
+		return 0, 0, false, errors.Wrap(err, "failed to parse config JSON")
 	}
 
-	// Debug: print inbounds found
-	fmt.Printf("[sing-box DEBUG] Found %d inbounds in config\n", len(config.Inbounds))
+	log.Debug("parsed config", slog.Int("inbounds", len(config.Inbounds)))
 	for i, inbound := range config.Inbounds {
-		fmt.Printf("[sing-box DEBUG] Inbound %d: type=%s, listen_port=%d\n", i, inbound.Type, inbound.ListenPort)
+		log.Debug("found inbound",
+			slog.Int("index", i),
+			slog.String("type", inbound.Type),
+			slog.Int("listen_port", inbound.ListenPort),
+		)
 	}
 
 	// Find SOCKS5, HTTP, and TUN inbounds
@@ -183,11 +260,16 @@ func WithConfigFile(configPath string) testcontainers.ContainerCustomizer {
 
 // Run starts a sing-box container with the provided configuration
 func Run(ctx context.Context, opts ...testcontainers.ContainerCustomizer) (*Env, error) {
+	image, err := common.ResolveImage(kind)
+	if err != nil {
+		return nil, err
+	}
+
 	// Create initial request
 	req := testcontainers.GenericContainerRequest{
 		Started: true,
 		ContainerRequest: testcontainers.ContainerRequest{
-			Image:             getSingBoxImage(),
+			Image:             image,
 			ImageSubstitutors: common.ImageSubstitutors(),
 			Cmd:               []string{"run", "-c", "/etc/sing-box/config.json"},
 			Env: map[string]string{
@@ -197,8 +279,9 @@ func Run(ctx context.Context, opts ...testcontainers.ContainerCustomizer) (*Env,
 	}
 
 	// Apply user-provided customizations
-	for _, e := range opts {
-		_ = e.Customize(&req) //nolint:errcheck // options pattern, errors handled during container creation
+	settings, err := common.Customize(kind, &req, opts...)
+	if err != nil {
+		return nil, err
 	}
 
 	// Validate that config file was provided
@@ -216,9 +299,9 @@ func Run(ctx context.Context, opts ...testcontainers.ContainerCustomizer) (*Env,
 	}
 
 	// Parse config to determine which ports to expose and if TUN is present
-	socks5Port, httpPort, hasTUN, err := parseConfigPorts(configPath)
+	socks5Port, httpPort, hasTUN, err := parseConfigPorts(configPath, settings.Logger)
 	if err != nil {
-		return nil, errors.Errorf("failed to parse config ports: %w", err)
+		return nil, errors.Wrap(err, "failed to parse config ports")
 	}
 
 	// Build list of exposed ports
@@ -276,67 +359,65 @@ func Run(ctx context.Context, opts ...testcontainers.ContainerCustomizer) (*Env,
 	// Privileged mode is the most reliable way to enable TUN in containers for testing
 	if hasTUN {
 		req.ContainerRequest.Privileged = true
-		fmt.Printf("[sing-box DEBUG] Enabling privileged mode for TUN support\n")
+		settings.Logger.Debug("enabling privileged mode for TUN support")
 	}
 
-	// Start the container
-	container, err := testcontainers.GenericContainer(ctx, req)
-	if err != nil {
-		// Try to get container logs if container was created but failed to start
-		var logsStr string
-		if container != nil {
-			logs, logErr := container.Logs(ctx)
-			if logErr == nil {
-				logBytes, readErr := io.ReadAll(logs)
-				if readErr == nil && len(logBytes) > 0 {
-					// Limit logs to 2000 characters for readability
-					logsStr = string(logBytes)
-					if len(logsStr) > 2000 {
-						logsStr = logsStr[:2000] + "\n... (truncated)"
-					}
-				}
+	env := &Env{
+		name: common.ServiceName(&req, kind),
+	}
+
+	alias := common.NetworkAlias(&req)
+
+	// Start the container, on failure it is terminated and its logs are attached to the error
+	container, err := common.Start(ctx, settings,
+		func(ctx context.Context) (testcontainers.Container, error) {
+			return testcontainers.GenericContainer(ctx, req)
+		},
+		func(ctx context.Context, container testcontainers.Container) error {
+			// Get host and mapped ports
+			host, err := container.Host(ctx)
+			if err != nil {
+				return errors.Wrap(err, "failed to get host")
 			}
-		}
 
-		if logsStr != "" {
-			return nil, errors.Errorf("failed to start sing-box container: %w\nContainer logs:\n%s", err, logsStr)
-		}
-		return nil, errors.Errorf("failed to start sing-box container: %w", err)
-	}
+			env.HostIP = host
 
-	// Get host and mapped ports
-	host, err := container.Host(ctx)
-	if err != nil {
-		_ = container.Terminate(ctx) //nolint:errcheck // best effort cleanup on error
-		return nil, errors.Errorf("failed to get host: %w", err)
-	}
+			// Get mapped SOCKS5 port
+			if socks5Port != 0 {
+				mappedPort, err := container.MappedPort(ctx, nat.Port(fmt.Sprintf("%d/tcp", socks5Port)))
+				if err != nil {
+					return errors.Wrap(err, "failed to get mapped SOCKS5 port")
+				}
+				env.SOCKS5Port = mappedPort.Port()
+				env.SOCKS5ProxyURL = fmt.Sprintf("socks5://%s:%s", host, env.SOCKS5Port)
 
-	env := &Env{
-		Container: container,
-		HostIP:    host,
-	}
+				if alias != "" {
+					env.InternalSOCKS5ProxyURL = fmt.Sprintf("socks5://%s:%d", alias, socks5Port)
+				}
+			}
 
-	// Get mapped SOCKS5 port
-	if socks5Port != 0 {
-		mappedPort, err := container.MappedPort(ctx, nat.Port(fmt.Sprintf("%d/tcp", socks5Port)))
-		if err != nil {
-			_ = container.Terminate(ctx) //nolint:errcheck // best effort cleanup on error
-			return nil, errors.Errorf("failed to get mapped SOCKS5 port: %w", err)
-		}
-		env.SOCKS5Port = mappedPort.Port()
-		env.SOCKS5ProxyURL = fmt.Sprintf("socks5://%s:%s", host, env.SOCKS5Port)
-	}
+			// Get mapped HTTP port
+			if httpPort != 0 {
+				mappedPort, err := container.MappedPort(ctx, nat.Port(fmt.Sprintf("%d/tcp", httpPort)))
+				if err != nil {
+					return errors.Wrap(err, "failed to get mapped HTTP port")
+				}
+				env.HTTPPort = mappedPort.Port()
+				env.HTTPProxyURL = fmt.Sprintf("http://%s:%s", host, env.HTTPPort)
 
-	// Get mapped HTTP port
-	if httpPort != 0 {
-		mappedPort, err := container.MappedPort(ctx, nat.Port(fmt.Sprintf("%d/tcp", httpPort)))
-		if err != nil {
-			_ = container.Terminate(ctx) //nolint:errcheck // best effort cleanup on error
-			return nil, errors.Errorf("failed to get mapped HTTP port: %w", err)
-		}
-		env.HTTPPort = mappedPort.Port()
-		env.HTTPProxyURL = fmt.Sprintf("http://%s:%s", host, env.HTTPPort)
+				if alias != "" {
+					env.InternalHTTPProxyURL = fmt.Sprintf("http://%s:%d", alias, httpPort)
+				}
+			}
+
+			return nil
+		},
+	)
+	if err != nil {
+		return nil, err
 	}
 
+	env.Container = container
+
 	return env, nil
 }
@@ -0,0 +1,298 @@
+// Package stack starts several services concurrently on a shared Docker network.
+//
+// Every service is described by a name, one of the existing Run functions
+// (psql.Run, redis.Run, kafka.Run, ...) and optional container customizers.
+// Services without pending dependencies are started in parallel, each one is
+// attached to a generated network under its own name as alias (so the
+// Internal* fields of every Env are filled), and a single Terminate call
+// tears everything down in reverse start order. As the network is new on every
+// run, common.WithReuse never finds an earlier container of a stack service.
+package stack
+
+import (
+	"context"
+	"maps"
+	"sync"
+
+	errors "github.com/go-faster/errors"
+	testcontainers "github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/network"
+
+	common "github.com/Educentr/goat-services/common"
+)
+
+type (
+	// Terminator is implemented by every Env returned from a Run function.
+	Terminator interface {
+		Terminate(ctx context.Context, opts ...testcontainers.TerminateOption) error
+	}
+
+	// RunFunc is the signature shared by the Run functions of all service packages.
+	RunFunc[T Terminator] func(ctx context.Context, opts ...testcontainers.ContainerCustomizer) (T, error)
+
+	// Stack is a set of named services started together on one network.
+	Stack struct {
+		mu       sync.Mutex
+		services []*service
+		byName   map[string]*service
+		started  []*service
+		err      error
+
+		labels        map[string]string
+		networkName   string
+		removeNetwork func(ctx context.Context) error
+	}
+
+	// Option configures a Stack.
+	Option func(*Stack)
+
+	// Handle gives typed access to a service of the stack once it has been started.
+	Handle[T Terminator] struct {
+		svc *service
+	}
+
+	service struct {
+		name     string
+		deps     []string
+		opts     []testcontainers.ContainerCustomizer
+		run      func(ctx context.Context, opts ...testcontainers.ContainerCustomizer) (Terminator, error)
+		instance Terminator
+	}
+)
+
+// newNetwork creates the network shared by all services of a stack.
+// Returns network name and cleanup function.
+var newNetwork = func(ctx context.Context, labels map[string]string) (string, func(ctx context.Context) error, error) {
+	all := common.SessionLabels()
+	maps.Copy(all, labels)
+
+	nw, err := network.New(ctx, network.WithLabels(all))
+	if err != nil {
+		return "", nil, err
+	}
+
+	return nw.Name, nw.Remove, nil
+}
+
+// New returns an empty stack.
+func New(opts ...Option) *Stack {
+	s := &Stack{byName: map[string]*service{}}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// WithLabels sets labels on the network and on every container of the stack.
+func WithLabels(labels map[string]string) Option {
+	return func(s *Stack) {
+		if s.labels == nil {
+			s.labels = map[string]string{}
+		}
+
+		maps.Copy(s.labels, labels)
+	}
+}
+
+// Add registers a service in the stack. The name is used as network alias,
+// so other services of the stack can reach it by that name.
+func Add[T Terminator](s *Stack, name string, run RunFunc[T], opts ...testcontainers.ContainerCustomizer) *Handle[T] {
+	svc := &service{
+		name: name,
+		opts: opts,
+		run: func(ctx context.Context, opts ...testcontainers.ContainerCustomizer) (Terminator, error) {
+			return run(ctx, opts...)
+		},
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.byName[name]; ok {
+		s.err = errors.Join(s.err, errors.Errorf("service %q is added twice", name))
+	} else {
+		s.byName[name] = svc
+		s.services = append(s.services, svc)
+	}
+
+	return &Handle[T]{svc: svc}
+}
+
+// DependsOn makes the service start only after the named services are ready.
+func (h *Handle[T]) DependsOn(names ...string) *Handle[T] {
+	h.svc.deps = append(h.svc.deps, names...)
+	return h
+}
+
+// Name returns the service name, which is also its network alias.
+func (h *Handle[T]) Name() string {
+	return h.svc.name
+}
+
+// Env returns the started service. It returns the zero value before Start succeeds.
+func (h *Handle[T]) Env() T {
+	env, _ := h.svc.instance.(T) //nolint:errcheck // zero value until started
+	return env
+}
+
+// Network returns the name of the network shared by the services.
+// It is empty until Start is called.
+func (s *Stack) Network() string {
+	return s.networkName
+}
+
+// Start creates the network and starts all services, running every service
+// as soon as its dependencies are ready. On failure the already started
+// services are terminated and the joined errors are returned.
+func (s *Stack) Start(ctx context.Context) error {
+	if s.err != nil {
+		return s.err
+	}
+
+	if err := s.validate(); err != nil {
+		return err
+	}
+
+	name, remove, err := newNetwork(ctx, s.labels)
+	if err != nil {
+		return errors.Wrap(err, "create network")
+	}
+
+	s.networkName = name
+	s.removeNetwork = remove
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg   sync.WaitGroup
+		errs = make([]error, len(s.services))
+		done = make(map[string]chan struct{}, len(s.services))
+	)
+
+	for _, svc := range s.services {
+		done[svc.name] = make(chan struct{})
+	}
+
+	for i, svc := range s.services {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			defer close(done[svc.name])
+
+			for _, dep := range svc.deps {
+				select {
+				case <-done[dep]:
+				case <-ctx.Done():
+					return
+				}
+
+				if s.byName[dep].instance == nil {
+					return
+				}
+			}
+
+			opts := append([]testcontainers.ContainerCustomizer{}, svc.opts...)
+			opts = append(opts, common.WithNetworkAlias(s.networkName, svc.name))
+			if len(s.labels) > 0 {
+				opts = append(opts, testcontainers.WithLabels(s.labels))
+			}
+
+			instance, err := svc.run(ctx, opts...)
+			if err != nil {
+				errs[i] = errors.Wrapf(err, "start %s", svc.name)
+				cancel()
+
+				return
+			}
+
+			s.mu.Lock()
+			svc.instance = instance
+			s.started = append(s.started, svc)
+			s.mu.Unlock()
+		}()
+	}
+
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		// ctx is canceled at this point, cleanup must not depend on it
+		return errors.Join(err, s.Terminate(context.WithoutCancel(ctx)))
+	}
+
+	return nil
+}
+
+// Terminate stops all started services in reverse start order and removes the network.
+func (s *Stack) Terminate(ctx context.Context) error {
+	s.mu.Lock()
+	started := s.started
+	s.started = nil
+	remove := s.removeNetwork
+	s.removeNetwork = nil
+	s.mu.Unlock()
+
+	var errs []error
+
+	for i := len(started) - 1; i >= 0; i-- {
+		svc := started[i]
+		if err := svc.instance.Terminate(ctx); err != nil {
+			errs = append(errs, errors.Wrapf(err, "terminate %s", svc.name))
+		}
+	}
+
+	if remove != nil {
+		if err := remove(ctx); err != nil {
+			errs = append(errs, errors.Wrap(err, "remove network"))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// validate checks that all dependencies are known and that there are no cycles.
+func (s *Stack) validate() error {
+	pending := make(map[string]int, len(s.services))
+	dependents := make(map[string][]string, len(s.services))
+
+	for _, svc := range s.services {
+		for _, dep := range svc.deps {
+			if _, ok := s.byName[dep]; !ok {
+				return errors.Errorf("service %q depends on unknown service %q", svc.name, dep)
+			}
+
+			dependents[dep] = append(dependents[dep], svc.name)
+		}
+
+		pending[svc.name] = len(svc.deps)
+	}
+
+	queue := make([]string, 0, len(s.services))
+	for _, svc := range s.services {
+		if pending[svc.name] == 0 {
+			queue = append(queue, svc.name)
+		}
+	}
+
+	resolved := 0
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		resolved++
+
+		for _, dependent := range dependents[name] {
+			pending[dependent]--
+			if pending[dependent] == 0 {
+				queue = append(queue, dependent)
+			}
+		}
+	}
+
+	if resolved != len(s.services) {
+		return errors.New("services have cyclic dependencies")
+	}
+
+	return nil
+}
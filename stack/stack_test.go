@@ -0,0 +1,157 @@
+package stack
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+
+	testcontainers "github.com/testcontainers/testcontainers-go"
+)
+
+type fakeEnv struct {
+	name string
+	log  *eventLog
+}
+
+func (f *fakeEnv) Terminate(context.Context, ...testcontainers.TerminateOption) error {
+	f.log.add("terminate " + f.name)
+	return nil
+}
+
+type eventLog struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (l *eventLog) add(event string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, event)
+}
+
+func fakeRun(name string, log *eventLog, fail bool) RunFunc[*fakeEnv] {
+	return func(_ context.Context, opts ...testcontainers.ContainerCustomizer) (*fakeEnv, error) {
+		req := testcontainers.GenericContainerRequest{}
+		for _, opt := range opts {
+			if err := opt.Customize(&req); err != nil {
+				return nil, err
+			}
+		}
+
+		if aliases := req.NetworkAliases["test-network"]; !slices.Equal(aliases, []string{name}) {
+			return nil, errors.New("unexpected network aliases")
+		}
+
+		if fail {
+			return nil, errors.New("boom")
+		}
+
+		log.add("start " + name)
+
+		return &fakeEnv{name: name, log: log}, nil
+	}
+}
+
+func withFakeNetwork(t *testing.T, log *eventLog) {
+	t.Helper()
+
+	orig := newNetwork
+	newNetwork = func(context.Context, map[string]string) (string, func(context.Context) error, error) {
+		return "test-network", func(context.Context) error {
+			log.add("remove network")
+			return nil
+		}, nil
+	}
+
+	t.Cleanup(func() { newNetwork = orig })
+}
+
+func TestStackStartsInDependencyOrder(t *testing.T) {
+	log := &eventLog{}
+	withFakeNetwork(t, log)
+
+	s := New()
+	db := Add(s, "db", fakeRun("db", log, false))
+	app := Add(s, "app", fakeRun("app", log, false)).DependsOn("db")
+
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	if db.Env() == nil || app.Env() == nil {
+		t.Fatalf("handles are not populated")
+	}
+
+	if err := s.Terminate(context.Background()); err != nil {
+		t.Fatalf("Terminate() error = %v", err)
+	}
+
+	expected := []string{"start db", "start app", "terminate app", "terminate db", "remove network"}
+	if !slices.Equal(log.events, expected) {
+		t.Errorf("events = %v, want %v", log.events, expected)
+	}
+}
+
+func TestStackFailureTerminatesStarted(t *testing.T) {
+	log := &eventLog{}
+	withFakeNetwork(t, log)
+
+	s := New()
+	Add(s, "db", fakeRun("db", log, false))
+	Add(s, "broken", fakeRun("broken", log, true)).DependsOn("db")
+	app := Add(s, "app", fakeRun("app", log, false)).DependsOn("broken")
+
+	if err := s.Start(context.Background()); err == nil {
+		t.Fatalf("Start() expected error")
+	}
+
+	if app.Env() != nil {
+		t.Errorf("dependent of failed service must not be started")
+	}
+
+	expected := []string{"start db", "terminate db", "remove network"}
+	if !slices.Equal(log.events, expected) {
+		t.Errorf("events = %v, want %v", log.events, expected)
+	}
+}
+
+func TestStackValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(s *Stack)
+	}{
+		{
+			name: "unknown dependency",
+			setup: func(s *Stack) {
+				Add(s, "app", fakeRun("app", nil, false)).DependsOn("db")
+			},
+		},
+		{
+			name: "cyclic dependency",
+			setup: func(s *Stack) {
+				Add(s, "a", fakeRun("a", nil, false)).DependsOn("b")
+				Add(s, "b", fakeRun("b", nil, false)).DependsOn("a")
+			},
+		},
+		{
+			name: "duplicate service",
+			setup: func(s *Stack) {
+				Add(s, "a", fakeRun("a", nil, false))
+				Add(s, "a", fakeRun("a", nil, false))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New()
+			tt.setup(s)
+
+			if err := s.Start(context.Background()); err == nil {
+				t.Errorf("Start() expected error")
+			}
+		})
+	}
+}
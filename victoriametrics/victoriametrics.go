@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"net/http"
+	"net/url"
 
 	errors "github.com/pkg/errors"
 	testcontainers "github.com/testcontainers/testcontainers-go"
@@ -16,17 +18,101 @@ type (
 	Env struct {
 		testcontainers.Container
 		Address string
+
+		// InternalAddress is http://alias:8428 when the container has a network alias.
+		InternalAddress string
+
+		name string
 	}
 )
 
+const kind = "victoriametrics"
+
 var (
-	defaultImage = common.DockerProxy("victoriametrics/victoria-metrics:v1.103.0")
+	_ common.Service  = (*Env)(nil)
+	_ common.Resetter = (*Env)(nil)
 )
 
+// ServiceName implements common.Service.
+func (e *Env) ServiceName() string {
+	return e.name
+}
+
+// Kind implements common.Service.
+func (e *Env) Kind() string {
+	return kind
+}
+
+// Endpoints implements common.Service.
+func (e *Env) Endpoints() map[string]string {
+	endpoints := map[string]string{
+		"http": e.Address,
+	}
+	common.AddInternalEndpoint(endpoints, "http", e.InternalAddress)
+
+	return endpoints
+}
+
+// HealthCheck implements common.Service.
+func (e *Env) HealthCheck(ctx context.Context) error {
+	return common.CheckHTTP(ctx, e.Address+"/health")
+}
+
+// Reset implements common.Resetter. It deletes all series.
+func (e *Env) Reset(ctx context.Context) error {
+	query := url.Values{"match[]": {`{__name__!=""}`}}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		e.Address+"/api/v1/admin/tsdb/delete_series?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return errors.Errorf("delete series: unexpected status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// ConnectionEnv implements common.Service.
+func (e *Env) ConnectionEnv() map[string]string {
+	return e.EnvVars("")
+}
+
+// EnvVars implements common.Service.
+func (e *Env) EnvVars(prefix string) map[string]string {
+	return common.PrefixEnv(prefix, map[string]string{
+		"VICTORIAMETRICS_URL": e.Address,
+	})
+}
+
+// Load returns the Env of the named service from a document written by
+// common.WriteJSON. The Env has no container attached, so it must not be terminated.
+func Load(doc *common.Document, name string) (*Env, error) {
+	env := &Env{name: name}
+	if err := doc.Decode(name, env); err != nil {
+		return nil, err
+	}
+
+	return env, nil
+}
+
 func Run(ctx context.Context, opts ...testcontainers.ContainerCustomizer) (*Env, error) {
+	image, err := common.ResolveImage(kind)
+	if err != nil {
+		return nil, err
+	}
+
 	req := testcontainers.GenericContainerRequest{
 		ContainerRequest: testcontainers.ContainerRequest{
-			Image:             defaultImage,
+			Image:             image,
 			ImageSubstitutors: common.ImageSubstitutors(),
 			ExposedPorts:      []string{"8428/tcp"},
 			WaitingFor:        wait.ForLog("starting server at"),
@@ -39,43 +125,46 @@ func Run(ctx context.Context, opts ...testcontainers.ContainerCustomizer) (*Env,
 		Started: true,
 	}
 
-	for _, e := range opts {
-		err := e.Customize(&req)
-
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	container, err := testcontainers.GenericContainer(ctx, req)
+	settings, err := common.Customize(kind, &req, opts...)
 	if err != nil {
 		return nil, err
 	}
 
-	host, err := container.Host(ctx)
-	if err != nil {
-		err = container.Terminate(ctx)
-		if err != nil {
-			return nil, err
-		}
+	var address string
 
-		return nil, errors.Errorf("Failed to get host: %v", err)
-	}
+	container, err := common.Start(ctx, settings,
+		func(ctx context.Context) (testcontainers.Container, error) {
+			return testcontainers.GenericContainer(ctx, req)
+		},
+		func(ctx context.Context, container testcontainers.Container) error {
+			host, err := container.Host(ctx)
+			if err != nil {
+				return errors.Wrap(err, "get host")
+			}
 
-	mappedPort, err := container.MappedPort(ctx, "8428")
-	if err != nil {
-		err = container.Terminate(ctx)
-		if err != nil {
-			return nil, err
-		}
+			mappedPort, err := container.MappedPort(ctx, "8428")
+			if err != nil {
+				return errors.Wrap(err, "get mapped port")
+			}
 
-		return nil, errors.Errorf("Failed to get mapped port: %v", err)
-	}
+			address = fmt.Sprintf("http://%s", net.JoinHostPort(host, mappedPort.Port()))
 
-	address := fmt.Sprintf("http://%s", net.JoinHostPort(host, mappedPort.Port()))
+			return nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
 
-	return &Env{
+	env := &Env{
 		Container: container,
 		Address:   address,
-	}, nil
+		name:      common.ServiceName(&req, kind),
+	}
+
+	if alias := common.NetworkAlias(&req); alias != "" {
+		env.InternalAddress = fmt.Sprintf("http://%s", net.JoinHostPort(alias, "8428"))
+	}
+
+	return env, nil
 }
@@ -15,11 +15,16 @@ import (
 type Env struct {
 	testcontainers.Container
 	EndpointURL string
+
+	// InternalEndpointURL is alias:443 when the container has a network alias.
+	InternalEndpointURL string
+
+	name string
 }
 
-var (
-	defaultImage = common.DockerProxy("teddysun/xray")
-)
+const kind = "xray"
+
+var _ common.Service = (*Env)(nil)
 
 // WithConfigFile sets a custom config file path for xray.
 // This option is REQUIRED - xray will not start without a config file.
@@ -35,11 +40,64 @@ func WithConfigFile(configPath string) testcontainers.ContainerCustomizer {
 	})
 }
 
+// ServiceName implements common.Service.
+func (e *Env) ServiceName() string {
+	return e.name
+}
+
+// Kind implements common.Service.
+func (e *Env) Kind() string {
+	return kind
+}
+
+// Endpoints implements common.Service.
+func (e *Env) Endpoints() map[string]string {
+	endpoints := map[string]string{
+		"proxy": e.EndpointURL,
+	}
+	common.AddInternalEndpoint(endpoints, "proxy", e.InternalEndpointURL)
+
+	return endpoints
+}
+
+// HealthCheck implements common.Service.
+func (e *Env) HealthCheck(ctx context.Context) error {
+	return common.CheckTCP(ctx, e.EndpointURL)
+}
+
+// ConnectionEnv implements common.Service.
+func (e *Env) ConnectionEnv() map[string]string {
+	return e.EnvVars("")
+}
+
+// EnvVars implements common.Service.
+func (e *Env) EnvVars(prefix string) map[string]string {
+	return common.PrefixEnv(prefix, map[string]string{
+		"XRAY_ENDPOINT": e.EndpointURL,
+	})
+}
+
+// Load returns the Env of the named service from a document written by
+// common.WriteJSON. The Env has no container attached, so it must not be terminated.
+func Load(doc *common.Document, name string) (*Env, error) {
+	env := &Env{name: name}
+	if err := doc.Decode(name, env); err != nil {
+		return nil, err
+	}
+
+	return env, nil
+}
+
 func Run(ctx context.Context, opts ...testcontainers.ContainerCustomizer) (*Env, error) {
+	image, err := common.ResolveImage(kind)
+	if err != nil {
+		return nil, err
+	}
+
 	req := testcontainers.GenericContainerRequest{
 		Started: true,
 		ContainerRequest: testcontainers.ContainerRequest{
-			Image:             defaultImage,
+			Image:             image,
 			ImageSubstitutors: common.ImageSubstitutors(),
 			WaitingFor:        wait.ForListeningPort("443/tcp"),
 			ExposedPorts: []string{
@@ -48,8 +106,9 @@ func Run(ctx context.Context, opts ...testcontainers.ContainerCustomizer) (*Env,
 		},
 	}
 
-	for _, e := range opts {
-		_ = e.Customize(&req) //nolint:errcheck // options pattern, errors handled during container creation
+	settings, err := common.Customize(kind, &req, opts...)
+	if err != nil {
+		return nil, err
 	}
 
 	// Validate that config file was provided
@@ -64,25 +123,38 @@ func Run(ctx context.Context, opts ...testcontainers.ContainerCustomizer) (*Env,
 		return nil, errors.New("xray config file is required: use xray.WithConfigFile() to specify the config path")
 	}
 
-	container, err := testcontainers.GenericContainer(ctx, req)
-	if err != nil {
-		return nil, err
-	}
+	var endpointURL string
 
-	host, err := container.Host(ctx)
-	if err != nil {
-		_ = container.Terminate(ctx) //nolint:errcheck // best effort cleanup on error
-		return nil, errors.Errorf("Failed to get host: %v", err)
-	}
+	container, err := common.Start(ctx, settings,
+		func(ctx context.Context) (testcontainers.Container, error) {
+			return testcontainers.GenericContainer(ctx, req)
+		},
+		func(ctx context.Context, container testcontainers.Container) error {
+			host, err := container.Host(ctx)
+			if err != nil {
+				return errors.Wrap(err, "get host")
+			}
+
+			mappedPort, err := container.MappedPort(ctx, "443")
+			if err != nil {
+				return errors.Wrap(err, "get mapped port")
+			}
 
-	mappedPort, err := container.MappedPort(ctx, "443")
+			endpointURL = fmt.Sprintf("%s:%d", host, mappedPort.Int())
+
+			return nil
+		},
+	)
 	if err != nil {
-		_ = container.Terminate(ctx) //nolint:errcheck // best effort cleanup on error
-		return nil, errors.Errorf("Failed to get mapped port: %v", err)
+		return nil, err
 	}
 
 	return &Env{
 		Container:   container,
-		EndpointURL: fmt.Sprintf("%s:%d", host, mappedPort.Int()),
+		EndpointURL: endpointURL,
+
+		InternalEndpointURL: common.InternalAddress(common.NetworkAlias(&req), "443"),
+
+		name: common.ServiceName(&req, kind),
 	}, nil
 }